@@ -0,0 +1,344 @@
+package main
+
+import (
+	"car-integration/models"
+	audit "car-integration/services/audit"
+	config "car-integration/services/config"
+	database "car-integration/services/database"
+	history "car-integration/services/history"
+	reload "car-integration/services/reload"
+	replay "car-integration/services/replay"
+	simulator "car-integration/services/simulator"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// replayCommand re-sends datagrams recorded by the input log of a listener (input_log_path) to a running instance.
+func replayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("target", "localhost", "host of the instance, datagrams are sent to their original receiving ports")
+	speed := flags.Float64("speed", 1, "speed of the replay, 1 for the original timing, 0 to send without delays")
+	vins := flags.String("vin", "", "comma separated VINs to replay, empty for all")
+	ports := flags.String("port", "", "comma separated receiving ports to replay, empty for all")
+	types := flags.String("type", "", "comma separated datagram types to replay, empty for all")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v replay [flags] <input log>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || *speed < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	options := replay.Options{
+		Target: *target,
+		Speed:  *speed,
+		Vins:   parseList(*vins),
+		Types:  parseList(*types),
+		Ports:  make(map[int]bool),
+	}
+	for port := range parseList(*ports) {
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("Invalid port %q", port)
+		}
+		options.Ports[portNumber] = true
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open input log: %v", err)
+	}
+	defer file.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stats, err := replay.Replay(ctx, file, options)
+	log.Printf("Replayed %v datagrams, %v filtered out, %v invalid lines of %v", stats.Sent, stats.Filtered, stats.Invalid, stats.Read)
+	if err != nil {
+		log.Fatalf("Replay stopped: %v", err)
+	}
+}
+
+// parseList returns the set of comma separated values.
+func parseList(list string) map[string]bool {
+	values := make(map[string]bool)
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values[value] = true
+		}
+	}
+	return values
+}
+
+// simulateCommand runs virtual vehicles against the vehicle listener of an instance.
+func simulateCommand(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configFilepath := flags.String("config", os.Getenv("CONFIG_FILE"), "configuration whose area the random routes are generated in")
+	target := flags.String("target", "localhost:4040", "address of the vehicle listener")
+	vehicles := flags.Int("vehicles", 5, "number of vehicles")
+	interval := flags.Duration("interval", 100*time.Millisecond, "interval of vehicle updates")
+	speed := flags.Float64("speed", 8, "cruise speed in m/s")
+	routesFilepath := flags.String("routes", "", "JSON list of routes, each a list of {\"lat\", \"lon\"} waypoints, random routes in the area if empty")
+	duration := flags.Duration("duration", 0, "duration of the simulation, 0 until interrupted")
+	loss := flags.Float64("loss", 0, "probability of losing a datagram in each direction")
+	duplicate := flags.Float64("duplicate", 0, "probability of duplicating a sent datagram")
+	delay := flags.Duration("delay", 0, "delay of every datagram")
+	jitter := flags.Duration("jitter", 0, "maximum random delay added to every datagram")
+	clockOffset := flags.Duration("clock-offset", 0, "offset of the vehicle clocks")
+	_ = flags.Parse(args)
+	if *vehicles <= 0 || *interval <= 0 || *loss < 0 || *loss > 1 || *duplicate < 0 || *duplicate > 1 || *delay < 0 || *jitter < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	*configFilepath = resolveConfigPath(*configFilepath)
+	cfg, err := config.Load(*configFilepath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	area, err := reload.LoadArea(cfg.Area)
+	if err != nil {
+		log.Fatalf("Failed to load area: %v", err)
+	}
+
+	options := simulator.Options{
+		Target:         *target,
+		Vehicles:       *vehicles,
+		UpdateInterval: *interval,
+		Speed:          float32(*speed),
+		Area:           area,
+		Impairments: simulator.Impairments{
+			Loss:        *loss,
+			Duplicate:   *duplicate,
+			Delay:       *delay,
+			Jitter:      *jitter,
+			ClockOffset: *clockOffset,
+		},
+	}
+	if *routesFilepath != "" {
+		options.Routes, err = simulator.LoadRoutes(*routesFilepath)
+		if err != nil {
+			log.Fatalf("Failed to load routes: %v", err)
+		}
+	}
+
+	simulation, err := simulator.NewSimulation(options)
+	if err != nil {
+		log.Fatalf("Failed to create simulation: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	log.Printf("Simulating %v vehicles against %v", *vehicles, *target)
+	simulation.Run(ctx)
+
+	stats := simulation.GetStats()
+	log.Printf("Sent %v datagrams, received %v, dropped %v by impairments, %v decisions, %v pings",
+		stats.Sent, stats.Received, stats.Dropped, stats.Decisions, stats.Pings)
+}
+
+// decisionsCommand prints the decisions recorded in the decision audit log (audit.decision_log_path) for incident analysis.
+func decisionsCommand(args []string) {
+	flags := flag.NewFlagSet("decisions", flag.ExitOnError)
+	configFilepath := flags.String("config", os.Getenv("CONFIG_FILE"), "configuration whose decision log is read if no log is given")
+	vins := flags.String("vin", "", "comma separated VINs, empty for all")
+	from := flags.String("from", "", "RFC 3339 time of the first decision, empty for no limit")
+	to := flags.String("to", "", "RFC 3339 time of the last decision, empty for no limit")
+	asJSON := flags.Bool("json", false, "print the decisions as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v decisions [flags] [decision log]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filter := audit.Filter{Vins: parseList(*vins)}
+	var err error
+	if *from != "" {
+		filter.From, err = time.Parse(time.RFC3339Nano, *from)
+		if err != nil {
+			log.Fatalf("Invalid -from: %v", err)
+		}
+	}
+	if *to != "" {
+		filter.To, err = time.Parse(time.RFC3339Nano, *to)
+		if err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+	}
+
+	logFilepath := flags.Arg(0)
+	if logFilepath == "" {
+		*configFilepath = resolveConfigPath(*configFilepath)
+		cfg, err := config.Load(*configFilepath)
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		logFilepath = cfg.Audit.DecisionLogPath
+	}
+	file, err := os.Open(logFilepath)
+	if err != nil {
+		log.Fatalf("Failed to open decision log: %v", err)
+	}
+	defer file.Close()
+
+	decisions, err := audit.Query(file, filter)
+	if err != nil {
+		log.Fatalf("Failed to read decision log: %v", err)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(decisions)
+		return
+	}
+	for _, decision := range decisions {
+		printDecision(decision)
+	}
+}
+
+// printDecision prints what the car reported before the decision, what it was told and whether it acknowledged it.
+func printDecision(decision *audit.Decision) {
+	fmt.Printf("Decision %v for %v\n", decision.Id, decision.Vin)
+	if received := decision.Received; received != nil {
+		fmt.Printf("  received %v from %v, index %v, sent at %v\n", received.Time, received.Source, received.SourceIndex, received.SourceTimestamp)
+		fmt.Printf("  message  %q\n", received.Message)
+		if telemetry := received.Telemetry; telemetry != nil {
+			fmt.Printf("  vehicle  %v: lat %v, lon %v, heading %v, speed %v m/s, front lidar %v, front ultrasonic %v\n",
+				received.TelemetryTimestamp, telemetry.Latitude, telemetry.Longitude, telemetry.GpsDirection,
+				telemetry.Speed, telemetry.FrontLidar, telemetry.FrontUltrasonic)
+		} else {
+			fmt.Println("  vehicle  no state known")
+		}
+	}
+	for _, forward := range decision.Forwards {
+		if forwarded := forward.Forwarded; forwarded != nil {
+			fmt.Printf("  forwarded %v to %v, index %v", forwarded.Time, forwarded.Target, forwarded.Index)
+		} else {
+			fmt.Printf("  forwarded index %v", forward.Outcome.Index)
+		}
+		switch {
+		case forward.Outcome != nil:
+			fmt.Printf(": %v %v after %v attempts\n", forward.Outcome.Event, forward.Outcome.Time, forward.Outcome.Attempts)
+		case forward.Forwarded.Reliable:
+			fmt.Println(": outcome not recorded")
+		default:
+			fmt.Println(": not tracked, reliable delivery is disabled")
+		}
+	}
+	if len(decision.Forwards) == 0 {
+		fmt.Println("  not forwarded")
+	}
+}
+
+// historyCommand queries the telemetry stored in TimescaleDB, like the /history/ API.
+func historyCommand(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v history track|vehicles [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	configFilepath := flags.String("config", os.Getenv("CONFIG_FILE"), "configuration of the database and area")
+	format := flags.String("format", history.FormatJSON, "output format: json, csv or geojson")
+	var run func(ctx context.Context, db *gorm.DB, area *models.Area) error
+
+	switch args[0] {
+	case "track":
+		vin := flags.String("vin", "", "VIN of the vehicle")
+		from := flags.String("from", "", "RFC 3339 start of the range, an hour before -to if empty")
+		to := flags.String("to", "", "RFC 3339 end of the range, now if empty")
+		interval := flags.Duration("interval", 0, "downsampling interval, 0 for all updates")
+		limit := flags.Int("limit", history.MaxRows, "maximum number of rows")
+		run = func(ctx context.Context, db *gorm.DB, area *models.Area) error {
+			query := history.TrackQuery{Vin: *vin, Interval: *interval, Limit: *limit}
+			var err error
+			query.To, err = history.ParseTime(*to, time.Now())
+			if err != nil {
+				return err
+			}
+			query.From, err = history.ParseTime(*from, query.To.Add(-time.Hour))
+			if err != nil {
+				return err
+			}
+			rows, err := history.Track(ctx, db, query)
+			if err != nil {
+				return err
+			}
+			return history.WriteTrack(os.Stdout, *format, rows)
+		}
+
+	case "vehicles":
+		at := flags.String("at", "", "RFC 3339 instant, now if empty")
+		window := flags.Duration("window", history.DefaultWindow, "maximum age of the last update of a present vehicle")
+		zone := flags.String("zone", "", "name of a zone of the area, empty for the whole area")
+		run = func(ctx context.Context, db *gorm.DB, area *models.Area) error {
+			query := history.PresenceQuery{Window: *window, Area: area, Zone: *zone}
+			var err error
+			query.At, err = history.ParseTime(*at, time.Now())
+			if err != nil {
+				return err
+			}
+			rows, err := history.VehiclesAt(ctx, db, query)
+			if err != nil {
+				return err
+			}
+			return history.WriteVehicles(os.Stdout, *format, rows)
+		}
+
+	default:
+		usage()
+	}
+	_ = flags.Parse(args[1:])
+	if _, ok := history.ContentTypes[*format]; !ok {
+		log.Fatalf("Unknown format %q", *format)
+	}
+
+	*configFilepath = resolveConfigPath(*configFilepath)
+	cfg, err := config.Load(*configFilepath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	area, err := reload.LoadArea(cfg.Area)
+	if err != nil {
+		log.Fatalf("Failed to load area: %v", err)
+	}
+	if cfg.Database.DSN == "" {
+		log.Fatal("database.dsn is not configured")
+	}
+	db, err := database.Open(cfg.Database.DSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = run(ctx, db, area)
+	if err != nil {
+		log.Fatalf("Query failed: %v", err)
+	}
+}
@@ -2,11 +2,30 @@ package main
 
 import (
 	"car-integration/models"
+	admin "car-integration/services/admin"
+	audit "car-integration/services/audit"
 	communication "car-integration/services/communication"
+	config "car-integration/services/config"
+	database "car-integration/services/database"
+	health "car-integration/services/health"
+	history "car-integration/services/history"
 	logger "car-integration/services/logger"
+	metrics "car-integration/services/metrics"
 	redis "car-integration/services/redis"
+	reload "car-integration/services/reload"
+	routing "car-integration/services/routing"
+	snapshot "car-integration/services/snapshot"
+	statistics "car-integration/services/statistics"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	api "github.com/TP-TEAM05/integration-api"
@@ -14,42 +33,268 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// defaultConfigFilepath is loaded if it exists and no other configuration file is given.
+const defaultConfigFilepath = "config.yaml"
+
+// resolveConfigPath returns the configuration file given by the flag, or defaultConfigFilepath if the flag is empty and the file exists.
+// Returns empty path for the defaults of config.Default otherwise.
+func resolveConfigPath(flagValue string) string {
+	if flagValue == "" {
+		if _, err := os.Stat(defaultConfigFilepath); err == nil {
+			return defaultConfigFilepath
+		}
+	}
+	return flagValue
+}
+
 func main() {
-	defer sentry.Flush(2 * time.Second)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		case "simulate":
+			simulateCommand(os.Args[2:])
+			return
+		case "decisions":
+			decisionsCommand(os.Args[2:])
+			return
+		case "history":
+			historyCommand(os.Args[2:])
+			return
+		}
+	}
+
+	configFilepath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML configuration, defaults to "+defaultConfigFilepath+" if it exists")
+	flag.Parse()
+	*configFilepath = resolveConfigPath(*configFilepath)
+
+	cfg, err := config.Load(*configFilepath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	zerolog.TimeFieldFormat = api.TimestampFormat
 
-	area := models.Area{
-		TopLeft: api.PositionJSON{
-			Lat: 0,
-			Lon: 0,
-		},
-		BottomRight: api.PositionJSON{
-			Lat: 0,
-			Lon: 0,
-		},
+	// Polygon of the area and its zones drawn by track designers, or the box of the corners
+	area, err := reload.LoadArea(cfg.Area)
+	if err != nil {
+		log.Fatalf("Failed to load area: %v", err)
+	}
+
+	reliableDelivery := &communication.ReliableDeliveryOptions{
+		RetransmitTimeout:    cfg.ReliableDelivery.RetransmitTimeout,
+		MaxRetransmitTimeout: cfg.ReliableDelivery.MaxRetransmitTimeout,
+		Deadline:             cfg.ReliableDelivery.Deadline,
 	}
 
-	var dataModel = communication.NewDataModel(&area, 0)
+	var dataModel = communication.NewDataModel(area, cfg.DataModel.NotificationDuration)
+	dataModel.HistoryDepth = cfg.DataModel.HistoryDepth
+	dataModel.HistoryDuration = cfg.DataModel.HistoryDuration
+	dataModel.UpdateQueueCapacity = cfg.DataModel.UpdateQueueCapacity
+	dataModel.UpdateQueueOverflow = cfg.DataModel.UpdateQueueOverflow
+	dataModel.Geofence = communication.NewGeofenceTracker(cfg.DataModel.GeofenceConfirmations, 1024)
+	dataModel.Reconfigure(area, cfg.AllowedVins, true)
+	if cfg.Audit.DecisionLogPath != "" {
+		dataModel.DecisionLog, err = audit.Open(cfg.Audit.DecisionLogPath)
+		if err != nil {
+			log.Fatalf("Failed to open decision audit log: %v", err)
+		}
+	}
+
+	// Hand-off of vehicles leaving the area to the neighbouring Integration Modules
+	if cfg.Area.NeighboursFile != "" {
+		neighbours, err := models.LoadNeighbours(cfg.Area.NeighboursFile)
+		if err != nil {
+			log.Fatalf("Failed to load neighbours: %v", err)
+		}
+		dataModel.Handoff, err = communication.NewHandoff(neighbours, reliableDelivery)
+		if err != nil {
+			log.Fatalf("Failed to initialize hand-off: %v", err)
+		}
+	}
+
+	if cfg.UsesRedis() {
+		redis.Init(cfg.Redis.Address, cfg.Redis.Password, cfg.Redis.DB)
+	}
+	if cfg.NetworkStats.Store == config.StatsStoreRedis {
+		dataModel.StatsStore = redis.NewStatsStore(redis.GetDB(), cfg.NetworkStats.Namespace, cfg.NetworkStats.TTL)
+	} else {
+		dataModel.StatsStore = statistics.NewMemoryStore(cfg.NetworkStats.TTL)
+	}
+	// Network statistics are written in batches, the memory store drops expired stats
+	go statistics.FlushEvery(context.Background(), dataModel.StatsStore, cfg.NetworkStats.FlushInterval)
 
-	redis.Init()
-	logger.Init()
+	logger.Init(cfg.Sentry.DSN, cfg.Sentry.TracesSampleRate)
+	routing.Init(cfg.Routing.RoutesFile, cfg.Routing.Routes)
+	if cfg.Database.DSN != "" {
+		database.Init(cfg.Database.DSN, database.TelemetryOptions{
+			BufferSize:    cfg.Database.TelemetryBufferSize,
+			BatchSize:     cfg.Database.TelemetryBatchSize,
+			FlushInterval: cfg.Database.TelemetryFlushInterval,
+		})
+	}
+
+	// Warm start from the last snapshot, restored cars are stale until they send an update
+	var snapshotter *snapshot.Snapshotter
+	if cfg.Snapshot.Store != "" {
+		var store snapshot.Store = &snapshot.FileStore{Path: cfg.Snapshot.Path}
+		if cfg.Snapshot.Store == config.SnapshotStoreRedis {
+			store = redis.NewSnapshotStore(redis.GetDB(), cfg.Snapshot.Key)
+		}
+		snapshotter = snapshot.NewSnapshotter(dataModel, store, cfg.Snapshot.Interval, cfg.Snapshot.MaxAge, cfg.Snapshot.StaleTimeout)
+		restoreCtx, cancelRestore := context.WithTimeout(context.Background(), 5*time.Second)
+		restored, err := snapshotter.Restore(restoreCtx)
+		cancelRestore()
+		if err != nil {
+			sentry.CaptureException(err)
+			log.Printf("Failed to restore snapshot, starting empty: %v", err)
+		} else if len(restored) > 0 {
+			log.Printf("Restored %v vehicles from snapshot: %v", len(restored), restored)
+		}
+		snapshotter.Start()
+	}
+
+	// Processors are pinged to measure round trip time, see processor-statistics topic.
+	// Decision updates to vehicles are retransmitted until acknowledged if the listener enables reliable delivery.
+	var managers []*communication.ConnectionsManager
+	listeners := make(map[string]*communication.ConnectionsManager)
+	for _, listener := range cfg.Listeners {
+		var inputLogFilepath *string
+		if listener.InputLogPath != "" {
+			inputLogFilepath = &listener.InputLogPath
+		}
+		manager := communication.NewConnectionsManager(dataModel, listener.Type, listener.KeepAliveTimeout, inputLogFilepath)
+		manager.PingInterval = listener.PingInterval
+		if listener.ReliableDelivery {
+			manager.ReliableDelivery = reliableDelivery
+		}
+		go manager.StartListening(listener.Port, true, listener.BindAddress)
+		log.Printf("Listener %v (%v) on %v:%v", listener.Name, listener.Type, listener.BindAddress, listener.Port)
+		managers = append(managers, manager)
+		listeners[listener.Name] = manager
+	}
+	reloader := reload.NewReloader(*configFilepath, cfg, dataModel, listeners)
 
-	// decision module
-	go communication.NewConnectionsManager(dataModel, "processor", 0, nil).
-		StartListening(6060, true, "0.0.0.0")
+	metrics.RegisterGaugeFunc("car_integration_vehicles", "Vehicles in the DataModel.", func() float64 {
+		return float64(dataModel.GetVehicleCount(true))
+	})
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/admin/", admin.NewServer(dataModel, managers, reloader).Handler())
+	http.Handle("/history/", history.NewServer(database.GetDB, func() *models.Area { return dataModel.GetArea(true) }).Handler())
 
-	// backend
-	go communication.NewConnectionsManager(dataModel, "processor", 0, nil).
-		StartListening(5050, true, "0.0.0.0")
+	// Health endpoints are served also outside localhost for the container orchestrator
+	healthHandler := health.NewChecker(dataModel, managers, cfg.UsesRedis()).Handler()
+	http.Handle("/healthz", healthHandler)
+	http.Handle("/readyz", healthHandler)
+	healthServer := &http.Server{Addr: cfg.HealthAddress, Handler: healthHandler}
+	go func() {
+		if err := healthServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
 
-	// car simulator
-	go communication.NewConnectionsManager(dataModel, "vehicle", 0, nil).
-		StartListening(4040, true, "0.0.0.0")
+	// Debug for pprof, Prometheus metrics and admin API
+	debugServer := &http.Server{Addr: cfg.DebugAddress}
+	go func() {
+		if err := debugServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
 
-	// Free processor connection
-	go communication.NewConnectionsManager(dataModel, "processor", 0, nil).
-		StartListening(4041, true, "0.0.0.0")
+	// SIGHUP applies the changed configuration file without dropping connections
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			report, err := reloader.Reload()
+			if err != nil {
+				log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+				continue
+			}
+			log.Printf("Configuration reloaded: %+v", *report)
+		}
+	}()
 
-	// Debug for pprof
-	log.Println(http.ListenAndServe("localhost:3030", nil))
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-signals.Done()
+	stop()
+
+	log.Printf("Shutting down, deadline %v", cfg.ShutdownTimeout)
+	// Steps blocked outside of the context, e.g. on Redis, must not keep the module running
+	time.AfterFunc(cfg.ShutdownTimeout, func() {
+		log.Println("Shutdown deadline exceeded, exiting")
+		os.Exit(1)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdown(ctx, dataModel, snapshotter, managers, []*http.Server{debugServer, healthServer}); err != nil {
+		log.Printf("Shutdown did not finish cleanly: %v", err)
+		os.Exit(1)
+	}
+	log.Println("Shutdown complete")
+}
+
+// shutdown saves the final snapshot, drains the listeners, finishes hand-offs, flushes the database and Sentry and stops the HTTP servers.
+// Every step gets the remaining time of the context, a step which runs out of it does not block the next ones.
+func shutdown(ctx context.Context, dataModel *communication.DataModel, snapshotter *snapshot.Snapshotter,
+	managers []*communication.ConnectionsManager, servers []*http.Server) error {
+	var errs []error
+
+	// The final snapshot is taken while the cars are still in the DataModel, draining removes them
+	if snapshotter != nil {
+		if err := snapshotter.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("saving snapshot: %w", err))
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, manager := range managers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := manager.Shutdown(ctx, "shutdown"); err != nil {
+				mutex.Lock()
+				errs = append(errs, fmt.Errorf("draining listener: %w", err))
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dataModel.Handoff != nil {
+		if err := dataModel.Handoff.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("closing hand-off: %w", err))
+		}
+	}
+
+	// Final statistics of the vehicles are saved when the listeners stop
+	if err := dataModel.StatsStore.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flushing network statistics: %w", err))
+	}
+
+	// Outcomes of pending decisions are recorded when the listeners stop
+	if err := dataModel.DecisionLog.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing decision audit log: %w", err))
+	}
+
+	if err := database.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("closing database: %w", err))
+	}
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping HTTP server %v: %w", server.Addr, err))
+		}
+	}
+
+	flushTimeout := 2 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		flushTimeout = max(time.Until(deadline), 0)
+	}
+	if !sentry.Flush(flushTimeout) {
+		errs = append(errs, errors.New("sentry events were not flushed"))
+	}
+	return errors.Join(errs...)
 }
@@ -2,14 +2,123 @@ package models
 
 import api "github.com/TP-TEAM05/integration-api"
 
+// Area managed by the Integration Module. It is either the box given by the corners,
+// which may cross the antimeridian (TopLeft.Lon > BottomRight.Lon), or the Polygon if set.
 type Area struct {
-	TopLeft     api.PositionJSON
-	BottomRight api.PositionJSON
+	TopLeft     api.PositionJSON `json:"top_left"`
+	BottomRight api.PositionJSON `json:"bottom_right"`
+	Polygon     *Polygon         `json:"polygon,omitempty"`
+	Zones       []Zone           `json:"zones,omitempty"`
 }
 
 func (area *Area) Contains(position *api.PositionJSON) bool {
-	return position.Lat <= area.TopLeft.Lat &&
-		position.Lat >= area.BottomRight.Lat &&
-		position.Lon >= area.TopLeft.Lon &&
-		position.Lon <= area.BottomRight.Lon
+	if area.Polygon != nil {
+		return area.Polygon.Contains(position)
+	}
+
+	if position.Lat > area.TopLeft.Lat || position.Lat < area.BottomRight.Lat {
+		return false
+	}
+	if area.TopLeft.Lon <= area.BottomRight.Lon {
+		return position.Lon >= area.TopLeft.Lon && position.Lon <= area.BottomRight.Lon
+	}
+	return position.Lon >= area.TopLeft.Lon || position.Lon <= area.BottomRight.Lon
+}
+
+// ZonesAt returns every zone of the area the position falls into.
+func (area *Area) ZonesAt(position *api.PositionJSON) []*Zone {
+	var zones []*Zone
+	for i := range area.Zones {
+		if area.Zones[i].Contains(position) {
+			zones = append(zones, &area.Zones[i])
+		}
+	}
+	return zones
+}
+
+// Polygon with holes. The first ring is the outer boundary, the others are holes.
+// Rings are lists of vertices, the closing vertex may be omitted.
+type Polygon struct {
+	Rings [][]api.PositionJSON `json:"rings"`
+}
+
+func (polygon *Polygon) Contains(position *api.PositionJSON) bool {
+	if len(polygon.Rings) == 0 || !ringContains(polygon.Rings[0], position) {
+		return false
+	}
+	for _, hole := range polygon.Rings[1:] {
+		if ringContains(hole, position) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds returns the corners of the box enclosing the outer ring. Longitudes are unwrapped like by ringContains,
+// so the box of a ring crossing the antimeridian crosses it as well (topLeft.Lon > bottomRight.Lon).
+func (polygon *Polygon) Bounds() (topLeft api.PositionJSON, bottomRight api.PositionJSON) {
+	if len(polygon.Rings) == 0 || len(polygon.Rings[0]) == 0 {
+		return
+	}
+	origin := polygon.Rings[0][0].Lon
+	topLeft.Lat = polygon.Rings[0][0].Lat
+	bottomRight.Lat = polygon.Rings[0][0].Lat
+	var west, east float64
+	for _, vertex := range polygon.Rings[0] {
+		topLeft.Lat = max(topLeft.Lat, vertex.Lat)
+		bottomRight.Lat = min(bottomRight.Lat, vertex.Lat)
+		lon := relativeLon(vertex.Lon, origin)
+		west = min(west, lon)
+		east = max(east, lon)
+	}
+	topLeft.Lon = float32(relativeLon(float32(float64(origin)+west), 0))
+	bottomRight.Lon = float32(relativeLon(float32(float64(origin)+east), 0))
+	return
+}
+
+// ringContains casts a ray from the position. Longitudes are unwrapped relative to the first vertex,
+// so rings crossing the antimeridian work as long as they span less than 180 degrees.
+func ringContains(ring []api.PositionJSON, position *api.PositionJSON) bool {
+	if len(ring) == 0 {
+		return false
+	}
+	origin := ring[0].Lon
+	x := relativeLon(position.Lon, origin)
+
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := relativeLon(ring[i].Lon, origin)-x, float64(ring[i].Lat-position.Lat)
+		xj, yj := relativeLon(ring[j].Lon, origin)-x, float64(ring[j].Lat-position.Lat)
+		if (yi > 0) != (yj > 0) && xi-yi*(xj-xi)/(yj-yi) > 0 {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func relativeLon(lon float32, origin float32) float64 {
+	difference := float64(lon - origin)
+	for difference >= 180 {
+		difference -= 360
+	}
+	for difference < -180 {
+		difference += 360
+	}
+	return difference
+}
+
+// Zone is a named part of the area, e.g. pit lane, intersection or parking.
+type Zone struct {
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Polygons []Polygon `json:"polygons"`
+}
+
+func (zone *Zone) Contains(position *api.PositionJSON) bool {
+	for i := range zone.Polygons {
+		if zone.Polygons[i].Contains(position) {
+			return true
+		}
+	}
+	return false
 }
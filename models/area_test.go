@@ -0,0 +1,111 @@
+package models
+
+import (
+	"testing"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// square returns the ring of the box with the corners, without the closing vertex.
+func square(west, south, east, north float32) []api.PositionJSON {
+	return []api.PositionJSON{
+		{Lat: south, Lon: west},
+		{Lat: south, Lon: east},
+		{Lat: north, Lon: east},
+		{Lat: north, Lon: west},
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	withHole := Polygon{Rings: [][]api.PositionJSON{square(0, 0, 10, 10), square(4, 4, 6, 6)}}
+	acrossAntimeridian := Polygon{Rings: [][]api.PositionJSON{square(170, 0, -170, 10)}}
+	triangle := Polygon{Rings: [][]api.PositionJSON{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 0}, {Lat: 0, Lon: 0}}}}
+
+	tests := []struct {
+		name     string
+		polygon  Polygon
+		position api.PositionJSON
+		want     bool
+	}{
+		{"inside", withHole, api.PositionJSON{Lat: 2, Lon: 2}, true},
+		{"outside", withHole, api.PositionJSON{Lat: 2, Lon: 12}, false},
+		{"inside hole", withHole, api.PositionJSON{Lat: 5, Lon: 5}, false},
+		{"between hole and boundary", withHole, api.PositionJSON{Lat: 5, Lon: 8}, true},
+		{"closed ring inside", triangle, api.PositionJSON{Lat: 2, Lon: 2}, true},
+		{"closed ring outside", triangle, api.PositionJSON{Lat: 6, Lon: 6}, false},
+		{"antimeridian east side", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: 179}, true},
+		{"antimeridian west side", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: -179}, true},
+		{"antimeridian outside west", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: 160}, false},
+		{"antimeridian outside east", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: -160}, false},
+		{"antimeridian opposite side", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: 0}, false},
+		{"no rings", Polygon{}, api.PositionJSON{Lat: 0, Lon: 0}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.polygon.Contains(&test.position); got != test.want {
+				t.Errorf("Contains(%+v) = %v, want %v", test.position, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAreaContains(t *testing.T) {
+	box := Area{TopLeft: api.PositionJSON{Lat: 10, Lon: 0}, BottomRight: api.PositionJSON{Lat: 0, Lon: 10}}
+	acrossAntimeridian := Area{TopLeft: api.PositionJSON{Lat: 10, Lon: 170}, BottomRight: api.PositionJSON{Lat: 0, Lon: -170}}
+	polygon := Area{
+		TopLeft:     api.PositionJSON{Lat: 10, Lon: 0},
+		BottomRight: api.PositionJSON{Lat: 0, Lon: 10},
+		Polygon:     &Polygon{Rings: [][]api.PositionJSON{square(0, 0, 10, 10), square(4, 4, 6, 6)}},
+	}
+
+	tests := []struct {
+		name     string
+		area     Area
+		position api.PositionJSON
+		want     bool
+	}{
+		{"box inside", box, api.PositionJSON{Lat: 5, Lon: 5}, true},
+		{"box on corner", box, api.PositionJSON{Lat: 10, Lon: 0}, true},
+		{"box above", box, api.PositionJSON{Lat: 11, Lon: 5}, false},
+		{"box east", box, api.PositionJSON{Lat: 5, Lon: 11}, false},
+		{"antimeridian box east side", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: 175}, true},
+		{"antimeridian box west side", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: -175}, true},
+		{"antimeridian box outside", acrossAntimeridian, api.PositionJSON{Lat: 5, Lon: 0}, false},
+		{"polygon overrides box", polygon, api.PositionJSON{Lat: 5, Lon: 5}, false},
+		{"polygon inside", polygon, api.PositionJSON{Lat: 2, Lon: 2}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.area.Contains(&test.position); got != test.want {
+				t.Errorf("Contains(%+v) = %v, want %v", test.position, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPolygonBounds(t *testing.T) {
+	tests := []struct {
+		name            string
+		polygon         Polygon
+		wantTopLeft     api.PositionJSON
+		wantBottomRight api.PositionJSON
+	}{
+		{"box", Polygon{Rings: [][]api.PositionJSON{square(1, 2, 3, 4)}},
+			api.PositionJSON{Lat: 4, Lon: 1}, api.PositionJSON{Lat: 2, Lon: 3}},
+		{"holes are ignored", Polygon{Rings: [][]api.PositionJSON{square(0, 0, 10, 10), square(-20, -20, 20, 20)}},
+			api.PositionJSON{Lat: 10, Lon: 0}, api.PositionJSON{Lat: 0, Lon: 10}},
+		{"across antimeridian", Polygon{Rings: [][]api.PositionJSON{square(170, 0, -170, 10)}},
+			api.PositionJSON{Lat: 10, Lon: 170}, api.PositionJSON{Lat: 0, Lon: -170}},
+		{"across antimeridian from the west", Polygon{Rings: [][]api.PositionJSON{square(-175, 0, 175, 10)}},
+			api.PositionJSON{Lat: 10, Lon: 175}, api.PositionJSON{Lat: 0, Lon: -175}},
+		{"no rings", Polygon{}, api.PositionJSON{}, api.PositionJSON{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			topLeft, bottomRight := test.polygon.Bounds()
+			if topLeft != test.wantTopLeft || bottomRight != test.wantBottomRight {
+				t.Errorf("Bounds() = %+v, %+v, want %+v, %+v", topLeft, bottomRight, test.wantTopLeft, test.wantBottomRight)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// AreaZoneKind is the kind of the GeoJSON feature describing the boundary of the area itself.
+const AreaZoneKind = "area"
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+	} `json:"properties"`
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// LoadGeoJSON reads the area from a GeoJSON FeatureCollection of Polygon and MultiPolygon features.
+// The feature with kind "area" is the boundary of the area, every other feature is a zone
+// named by its "name" property. Without an "area" feature the area is the box enclosing all zones,
+// a collection with neither is an error.
+func LoadGeoJSON(filepath string) (*Area, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var collection geoJSONFeatureCollection
+	err = json.Unmarshal(data, &collection)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", filepath, err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("%v is not a GeoJSON FeatureCollection", filepath)
+	}
+
+	area := &Area{}
+	for i, feature := range collection.Features {
+		polygons, err := parseGeoJSONGeometry(feature.Geometry.Type, feature.Geometry.Coordinates)
+		if err != nil {
+			return nil, fmt.Errorf("feature %v (%q) in %v: %w", i, feature.Properties.Name, filepath, err)
+		}
+
+		if feature.Properties.Kind == AreaZoneKind {
+			if len(polygons) != 1 {
+				return nil, fmt.Errorf("area feature in %v must be a single Polygon", filepath)
+			}
+			area.Polygon = &polygons[0]
+			continue
+		}
+		if feature.Properties.Name == "" {
+			return nil, fmt.Errorf("feature %v in %v has no name", i, filepath)
+		}
+		area.Zones = append(area.Zones, Zone{
+			Name:     feature.Properties.Name,
+			Kind:     feature.Properties.Kind,
+			Polygons: polygons,
+		})
+	}
+
+	if area.Polygon == nil && len(area.Zones) == 0 {
+		return nil, fmt.Errorf("%v has neither an area feature nor any zone to bound the area", filepath)
+	}
+	if area.Polygon != nil {
+		area.TopLeft, area.BottomRight = area.Polygon.Bounds()
+	} else {
+		var outer []api.PositionJSON
+		for _, zone := range area.Zones {
+			for _, polygon := range zone.Polygons {
+				outer = append(outer, polygon.Rings[0]...)
+			}
+		}
+		bounds := Polygon{Rings: [][]api.PositionJSON{outer}}
+		area.TopLeft, area.BottomRight = bounds.Bounds()
+	}
+	return area, nil
+}
+
+func parseGeoJSONGeometry(geometryType string, coordinates json.RawMessage) ([]Polygon, error) {
+	switch geometryType {
+	case "Polygon":
+		var rings [][][]float64
+		err := json.Unmarshal(coordinates, &rings)
+		if err != nil {
+			return nil, err
+		}
+		polygon, err := parseGeoJSONPolygon(rings)
+		if err != nil {
+			return nil, err
+		}
+		return []Polygon{polygon}, nil
+	case "MultiPolygon":
+		var multiPolygon [][][][]float64
+		err := json.Unmarshal(coordinates, &multiPolygon)
+		if err != nil {
+			return nil, err
+		}
+		if len(multiPolygon) == 0 {
+			return nil, fmt.Errorf("multipolygon has no polygons")
+		}
+		polygons := make([]Polygon, len(multiPolygon))
+		for i, rings := range multiPolygon {
+			polygons[i], err = parseGeoJSONPolygon(rings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return polygons, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", geometryType)
+	}
+}
+
+// parseGeoJSONPolygon converts GeoJSON rings of [lon, lat] pairs.
+func parseGeoJSONPolygon(rings [][][]float64) (Polygon, error) {
+	if len(rings) == 0 {
+		return Polygon{}, fmt.Errorf("polygon has no rings")
+	}
+
+	polygon := Polygon{Rings: make([][]api.PositionJSON, len(rings))}
+	for i, ring := range rings {
+		if len(ring) < 3 {
+			return Polygon{}, fmt.Errorf("ring %v has less than 3 vertices", i)
+		}
+		polygon.Rings[i] = make([]api.PositionJSON, len(ring))
+		for j, coordinate := range ring {
+			if len(coordinate) < 2 {
+				return Polygon{}, fmt.Errorf("vertex %v of ring %v is not a [lon, lat] pair", j, i)
+			}
+			polygon.Rings[i][j] = api.PositionJSON{Lat: float32(coordinate[1]), Lon: float32(coordinate[0])}
+		}
+	}
+	return polygon, nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// writeGeoJSON writes the features into a FeatureCollection file and returns its path.
+func writeGeoJSON(t *testing.T, features ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "area.geojson")
+	data := `{"type": "FeatureCollection", "features": [` + strings.Join(features, ",") + `]}`
+	err := os.WriteFile(path, []byte(data), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func feature(name string, kind string, geometryType string, coordinates string) string {
+	return `{"type": "Feature", "properties": {"name": "` + name + `", "kind": "` + kind + `"},
+		"geometry": {"type": "` + geometryType + `", "coordinates": ` + coordinates + `}}`
+}
+
+func TestLoadGeoJSON(t *testing.T) {
+	boundary := feature("track", AreaZoneKind, "Polygon", `[[[0, 0], [10, 0], [10, 8], [0, 8], [0, 0]]]`)
+	pitLane := feature("pit-lane", "pit", "Polygon", `[[[1, 1], [2, 1], [2, 2], [1, 2]], [[1.4, 1.4], [1.6, 1.4], [1.6, 1.6]]]`)
+	parking := feature("parking", "parking", "MultiPolygon", `[[[[5, -1], [6, -1], [6, 1], [5, 1]]], [[[3, 3], [4, 3], [4, 4]]]]`)
+	eastOfAntimeridian := feature("east", "zone", "Polygon", `[[[175, 0], [178, 0], [178, 2], [175, 2]]]`)
+	westOfAntimeridian := feature("west", "zone", "Polygon", `[[[-178, -1], [-175, -1], [-175, 1], [-178, 1]]]`)
+
+	tests := []struct {
+		name            string
+		features        []string
+		wantZones       int
+		wantPolygon     bool
+		wantTopLeft     api.PositionJSON
+		wantBottomRight api.PositionJSON
+	}{
+		{"area feature bounds the area", []string{boundary, pitLane}, 1, true,
+			api.PositionJSON{Lat: 8, Lon: 0}, api.PositionJSON{Lat: 0, Lon: 10}},
+		{"zones bound the area without area feature", []string{pitLane, parking}, 2, false,
+			api.PositionJSON{Lat: 4, Lon: 1}, api.PositionJSON{Lat: -1, Lon: 6}},
+		{"zones bound the area across antimeridian", []string{eastOfAntimeridian, westOfAntimeridian}, 2, false,
+			api.PositionJSON{Lat: 2, Lon: 175}, api.PositionJSON{Lat: -1, Lon: -175}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			area, err := LoadGeoJSON(writeGeoJSON(t, test.features...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(area.Zones) != test.wantZones || (area.Polygon != nil) != test.wantPolygon {
+				t.Errorf("%v zones and polygon %v, want %v zones and polygon %v",
+					len(area.Zones), area.Polygon != nil, test.wantZones, test.wantPolygon)
+			}
+			if area.TopLeft != test.wantTopLeft || area.BottomRight != test.wantBottomRight {
+				t.Errorf("corners %+v, %+v, want %+v, %+v", area.TopLeft, area.BottomRight, test.wantTopLeft, test.wantBottomRight)
+			}
+		})
+	}
+}
+
+func TestLoadGeoJSONZones(t *testing.T) {
+	path := writeGeoJSON(t,
+		feature("pit-lane", "pit", "Polygon", `[[[1, 1], [2, 1], [2, 2], [1, 2]], [[1.4, 1.4], [1.6, 1.4], [1.6, 1.6], [1.4, 1.6]]]`),
+		feature("parking", "parking", "MultiPolygon", `[[[[5, -1], [6, -1], [6, 1], [5, 1]]], [[[3, 3], [4, 3], [4, 4], [3, 4]]]]`))
+	area, err := LoadGeoJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		position api.PositionJSON
+		want     string
+	}{
+		{"zone", api.PositionJSON{Lat: 1.2, Lon: 1.2}, "pit-lane"},
+		{"hole of zone", api.PositionJSON{Lat: 1.5, Lon: 1.5}, ""},
+		{"first polygon of multipolygon", api.PositionJSON{Lat: 0, Lon: 5.5}, "parking"},
+		{"second polygon of multipolygon", api.PositionJSON{Lat: 3.5, Lon: 3.5}, "parking"},
+		{"no zone", api.PositionJSON{Lat: 7, Lon: 7}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zones := area.ZonesAt(&test.position)
+			var got string
+			if len(zones) > 0 {
+				got = zones[0].Name
+			}
+			if len(zones) > 1 || got != test.want {
+				t.Errorf("ZonesAt(%+v) = %v zones, first %q, want %q", test.position, len(zones), got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadGeoJSONRejectsInvalidFiles(t *testing.T) {
+	square := `[[[0, 0], [1, 0], [1, 1], [0, 1]]]`
+	tests := []struct {
+		name     string
+		features []string
+	}{
+		{"neither area nor zones", nil},
+		{"empty polygon", []string{feature("zone", "zone", "Polygon", `[]`)}},
+		{"empty multipolygon", []string{feature("zone", "zone", "MultiPolygon", `[]`)}},
+		{"multipolygon with empty polygon", []string{feature("zone", "zone", "MultiPolygon", `[[]]`)}},
+		{"ring with two vertices", []string{feature("zone", "zone", "Polygon", `[[[0, 0], [1, 1]]]`)}},
+		{"vertex without latitude", []string{feature("zone", "zone", "Polygon", `[[[0], [1, 0], [1, 1]]]`)}},
+		{"unsupported geometry", []string{feature("zone", "zone", "Point", `[0, 0]`)}},
+		{"zone without name", []string{feature("", "zone", "Polygon", square)}},
+		{"area of several polygons", []string{feature("track", AreaZoneKind, "MultiPolygon", `[`+square+`, `+square+`]`)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			area, err := LoadGeoJSON(writeGeoJSON(t, test.features...))
+			if err == nil {
+				t.Errorf("loaded %+v, expected an error", area)
+			}
+		})
+	}
+
+	t.Run("not a feature collection", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "area.geojson")
+		if err := os.WriteFile(path, []byte(`{"type": "Feature"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGeoJSON(path); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
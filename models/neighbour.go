@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Neighbour is an adjacent area managed by another Integration Module instance.
+type Neighbour struct {
+	Name            string `json:"name"`
+	Area            Area   `json:"area"`
+	VehicleEndpoint string `json:"vehicle_endpoint"` // host:port vehicles reconnect to
+	HandoffEndpoint string `json:"handoff_endpoint"` // host:port of the processor listener receiving vehicle state
+}
+
+// LoadNeighbours reads a JSON list of neighbours from the file.
+func LoadNeighbours(filepath string) ([]Neighbour, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbours []Neighbour
+	err = json.Unmarshal(data, &neighbours)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", filepath, err)
+	}
+
+	for _, neighbour := range neighbours {
+		if neighbour.Name == "" || neighbour.VehicleEndpoint == "" || neighbour.HandoffEndpoint == "" {
+			return nil, fmt.Errorf("neighbour %q in %v is missing name or endpoints", neighbour.Name, filepath)
+		}
+	}
+	return neighbours, nil
+}
+
+// FindNeighbour returns the first neighbour whose area contains the position, nil if there is none.
+func FindNeighbour(neighbours []Neighbour, position *api.PositionJSON) *Neighbour {
+	for i := range neighbours {
+		if neighbours[i].Area.Contains(position) {
+			return &neighbours[i]
+		}
+	}
+	return nil
+}
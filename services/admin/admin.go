@@ -0,0 +1,243 @@
+package admin
+
+import (
+	communication "car-integration/services/communication"
+	metrics "car-integration/services/metrics"
+	reload "car-integration/services/reload"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Server is the REST API for inspecting and controlling the live state of the Integration Module.
+type Server struct {
+	DataModel *communication.DataModel
+	Managers  []*communication.ConnectionsManager
+	Reloader  *reload.Reloader
+}
+
+func NewServer(dataModel *communication.DataModel, managers []*communication.ConnectionsManager, reloader *reload.Reloader) *Server {
+	return &Server{
+		DataModel: dataModel,
+		Managers:  managers,
+		Reloader:  reloader,
+	}
+}
+
+// Handler returns the API routes, all of them under /admin/.
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/connections", server.listConnections)
+	mux.HandleFunc("DELETE /admin/connections/{port}/{address}", server.deleteConnection)
+	mux.HandleFunc("GET /admin/subscriptions", server.listSubscriptions)
+	mux.HandleFunc("GET /admin/vehicles", server.listVehicles)
+	mux.HandleFunc("DELETE /admin/vehicles/{vin}", server.deleteVehicle)
+	mux.HandleFunc("GET /admin/decisions", server.listDecisions)
+	mux.HandleFunc("POST /admin/vehicles/{vin}/decision", server.injectDecision)
+	mux.HandleFunc("POST /admin/reload", server.reload)
+	return mux
+}
+
+type managerJSON struct {
+	Port           int              `json:"port"`
+	ConnectionType string           `json:"connection_type"`
+	Connections    []connectionJSON `json:"connections"`
+}
+
+type connectionJSON struct {
+	Address          string             `json:"address"`
+	Vin              string             `json:"vin,omitempty"`
+	KeepAliveTimeout float32            `json:"keep_alive_timeout"`
+	Subscriptions    []subscriptionJSON `json:"subscriptions"`
+}
+
+type subscriptionJSON struct {
+	Address  string  `json:"address"`
+	Port     int     `json:"port"`
+	Content  string  `json:"content"`
+	Topic    string  `json:"topic"`
+	Interval float32 `json:"interval"`
+	Replay   float32 `json:"replay,omitempty"`
+	Overflow string  `json:"overflow,omitempty"`
+}
+
+func (server *Server) listConnections(writer http.ResponseWriter, request *http.Request) {
+	managers := make([]managerJSON, len(server.Managers))
+	for i, manager := range server.Managers {
+		manager.Lock()
+		managers[i] = managerJSON{
+			Port:           manager.Port,
+			ConnectionType: manager.ConnectionType,
+			Connections:    []connectionJSON{},
+		}
+		manager.Unlock()
+
+		for _, connection := range manager.GetConnections(true) {
+			managers[i].Connections = append(managers[i].Connections, describeConnection(connection, managers[i].Port))
+		}
+	}
+	writeJSON(writer, http.StatusOK, managers)
+}
+
+func (server *Server) deleteConnection(writer http.ResponseWriter, request *http.Request) {
+	manager := server.findManager(request.PathValue("port"))
+	if manager == nil {
+		http.Error(writer, "no listener on port "+request.PathValue("port"), http.StatusNotFound)
+		return
+	}
+	connection := manager.GetConnection(request.PathValue("address"), true)
+	if connection == nil {
+		http.Error(writer, "no connection from "+request.PathValue("address"), http.StatusNotFound)
+		return
+	}
+
+	fmt.Printf("Admin API discarding connection from %v\n", request.PathValue("address"))
+	manager.DeleteConnection(connection, true)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) listSubscriptions(writer http.ResponseWriter, request *http.Request) {
+	subscriptions := []subscriptionJSON{}
+	for _, manager := range server.Managers {
+		manager.Lock()
+		port := manager.Port
+		manager.Unlock()
+
+		for _, connection := range manager.GetConnections(true) {
+			subscriptions = append(subscriptions, describeConnection(connection, port).Subscriptions...)
+		}
+	}
+	writeJSON(writer, http.StatusOK, subscriptions)
+}
+
+func (server *Server) listVehicles(writer http.ResponseWriter, request *http.Request) {
+	vehicles, _ := server.DataModel.GetVehicleStates(true)
+	writeJSON(writer, http.StatusOK, vehicles)
+}
+
+func (server *Server) deleteVehicle(writer http.ResponseWriter, request *http.Request) {
+	vin := request.PathValue("vin")
+	vehicles, _ := server.DataModel.GetVehicleStates(true)
+	if _, ok := vehicles[vin]; !ok {
+		http.Error(writer, "no vehicle "+vin, http.StatusNotFound)
+		return
+	}
+
+	fmt.Printf("Admin API dropping vehicle %v\n", vin)
+	server.DataModel.RemoveVehicle(vin, communication.GeofenceReasonRemoved, true)
+	metrics.DeleteVehicle(vin)
+
+	// Otherwise the next datagram of the connection would bring the vehicle back, the car reconnects as a new vehicle
+	for _, manager := range server.Managers {
+		for _, connection := range manager.GetConnections(true) {
+			vehicleConnection, ok := connection.(*communication.VehicleConnection)
+			if !ok {
+				continue
+			}
+			vehicleConnection.Lock()
+			matches := vehicleConnection.VinNumber == vin
+			vehicleConnection.Unlock()
+			if matches {
+				connection.Disconnect("removed by admin", true)
+				manager.DeleteConnection(connection, true)
+			}
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) listDecisions(writer http.ResponseWriter, request *http.Request) {
+	_, decisions := server.DataModel.GetVehicleStates(true)
+	writeJSON(writer, http.StatusOK, decisions)
+}
+
+// injectDecision forwards the test decision to the vehicle as if it came from the decision module.
+func (server *Server) injectDecision(writer http.ResponseWriter, request *http.Request) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		http.Error(writer, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	datagram := &api.UpdateVehicleDecisionDatagram{
+		BaseDatagram: api.BaseDatagram{
+			Type:      "decision_update",
+			Timestamp: time.Now().UTC().Format(api.TimestampFormat),
+		},
+		VehicleDecision: api.UpdateVehicleDecision{
+			Vin:     request.PathValue("vin"),
+			Message: body.Message,
+		},
+	}
+	fmt.Printf("Admin API injecting decision for %v: %v\n", datagram.VehicleDecision.Vin, body.Message)
+	server.DataModel.UpdateVehicleDecision(nil, datagram, true)
+	writeJSON(writer, http.StatusAccepted, datagram.VehicleDecision)
+}
+
+// reload applies the changed configuration file, the current configuration is kept if the new one is invalid.
+func (server *Server) reload(writer http.ResponseWriter, request *http.Request) {
+	report, err := server.Reloader.Reload()
+	if err != nil {
+		http.Error(writer, "reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("Admin API reloaded configuration: %+v\n", *report)
+	writeJSON(writer, http.StatusOK, report)
+}
+
+func (server *Server) findManager(port string) *communication.ConnectionsManager {
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	for _, manager := range server.Managers {
+		manager.Lock()
+		managerPort := manager.Port
+		manager.Unlock()
+		if managerPort == portNumber {
+			return manager
+		}
+	}
+	return nil
+}
+
+func describeConnection(connection communication.IConnection, port int) connectionJSON {
+	description := connectionJSON{
+		Address:          connection.GetClientAddress(true).String(),
+		KeepAliveTimeout: connection.GetKeepAliveTimeout(true),
+		Subscriptions:    []subscriptionJSON{},
+	}
+	if vehicleConnection, ok := connection.(*communication.VehicleConnection); ok {
+		vehicleConnection.Lock()
+		description.Vin = vehicleConnection.VinNumber
+		vehicleConnection.Unlock()
+	}
+	for _, subscription := range connection.GetSubscriptions(true) {
+		description.Subscriptions = append(description.Subscriptions, subscriptionJSON{
+			Address:  description.Address,
+			Port:     port,
+			Content:  subscription.Content,
+			Topic:    subscription.Topic,
+			Interval: subscription.Interval,
+			Replay:   subscription.Replay,
+			Overflow: subscription.Overflow,
+		})
+	}
+	return description
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(value)
+	if err != nil {
+		fmt.Printf("Failed to write admin API response: %v\n", err)
+	}
+}
@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+	"github.com/getsentry/sentry-go"
+)
+
+// Events of a decision recorded in the audit log
+const (
+	EventReceived     = "received"     // The processor sent the decision
+	EventForwarded    = "forwarded"    // The decision was sent to the vehicle
+	EventAcknowledged = "acknowledged" // The vehicle acknowledged the forwarded decision
+	EventExpired      = "expired"      // The vehicle did not acknowledge the forwarded decision before the deadline
+	EventSuperseded   = "superseded"   // A newer decision was forwarded before the vehicle acknowledged this one
+	EventStopped      = "stopped"      // The connection to the vehicle ended before it acknowledged the decision
+)
+
+// Record is one line of the audit log. Fields not related to the event are empty.
+type Record struct {
+	Event      string `json:"event"`
+	DecisionId string `json:"decision_id"`
+	Vin        string `json:"vin"`
+	Time       string `json:"time"` // Time of the event in the clock of the module, api.TimestampFormat
+
+	// Received
+	Message            string                    `json:"message,omitempty"`
+	Source             string                    `json:"source,omitempty"` // Address of the processor
+	SourceIndex        int                       `json:"source_index,omitempty"`
+	SourceTimestamp    string                    `json:"source_timestamp,omitempty"`
+	Telemetry          *api.UpdateVehicleVehicle `json:"telemetry,omitempty"` // Last state of the vehicle known when the decision arrived
+	TelemetryTimestamp string                    `json:"telemetry_timestamp,omitempty"`
+
+	// Forwarded and its outcome
+	Target   string `json:"target,omitempty"` // Address of the vehicle
+	Index    int    `json:"index,omitempty"`
+	Reliable bool   `json:"reliable,omitempty"` // Whether the outcome of the delivery is tracked
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// RecordBufferSize is the number of records waiting to be written, further records are dropped while it is full.
+const RecordBufferSize = 4096
+
+// DecisionLog appends the records to a JSON Lines file, a nil DecisionLog discards them.
+// Records are written by a goroutine, so a slow disk does not block the DataModel or the reliable delivery.
+type DecisionLog struct {
+	sync.Mutex
+	File    *os.File
+	Session string // Prefix of the decision ids, unique per start of the module
+	NextId  int
+	Dropped int64 // Records dropped as the buffer was full
+
+	records chan Record
+	closed  bool
+	done    chan struct{}
+}
+
+func Open(path string) (*DecisionLog, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log := &DecisionLog{
+		File:    file,
+		Session: newSession(),
+		NextId:  1,
+		records: make(chan Record, RecordBufferSize),
+		done:    make(chan struct{}),
+	}
+	go log.run()
+	return log, nil
+}
+
+// newSession returns the start time followed by random bytes, so starts within the same second do not share decision ids.
+func newSession() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405") + "." + hex.EncodeToString(suffix)
+}
+
+// NewId returns an id of a decision unique across restarts of the module.
+func (log *DecisionLog) NewId() string {
+	if log == nil {
+		return ""
+	}
+	log.Lock()
+	defer log.Unlock()
+	id := fmt.Sprintf("%v-%v", log.Session, log.NextId)
+	log.NextId++
+	return id
+}
+
+// Write queues the record for appending, Time is set to now if empty. It never waits for the file.
+func (log *DecisionLog) Write(record Record) {
+	if log == nil {
+		return
+	}
+	if record.Time == "" {
+		record.Time = time.Now().UTC().Format(api.TimestampFormat)
+	}
+
+	log.Lock()
+	defer log.Unlock()
+	if log.closed {
+		return
+	}
+	select {
+	case log.records <- record:
+	default:
+		log.Dropped++
+		fmt.Printf("Decision audit log is falling behind, dropped %v %v record of %v (%v in total)\n",
+			record.Event, record.DecisionId, record.Vin, log.Dropped)
+	}
+}
+
+// run appends the queued records until Close.
+func (log *DecisionLog) run() {
+	defer close(log.done)
+	for record := range log.records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			sentry.CaptureException(err)
+			fmt.Printf("Error marshalling audit record %v with error %v\n", record, err)
+			continue
+		}
+		_, err = log.File.Write(append(data, '\n'))
+		if err != nil {
+			sentry.CaptureException(err)
+			fmt.Printf("Failed to write decision audit log: %v\n", err)
+		}
+	}
+}
+
+// Close writes the queued records and closes the file, later records are discarded.
+func (log *DecisionLog) Close() error {
+	if log == nil {
+		return nil
+	}
+	log.Lock()
+	if !log.closed {
+		log.closed = true
+		close(log.records)
+	}
+	log.Unlock()
+
+	<-log.done
+	return log.File.Close()
+}
+
+// Decision is the history of one decision reconstructed from the audit log.
+type Decision struct {
+	Id       string     `json:"id"`
+	Vin      string     `json:"vin"`
+	Received *Record    `json:"received"` // Nil if the log starts after the decision arrived
+	Forwards []*Forward `json:"forwards"`
+}
+
+// Forward is one datagram carrying the decision to the vehicle.
+type Forward struct {
+	Forwarded *Record `json:"forwarded"`
+	Outcome   *Record `json:"outcome"` // Nil if unknown, e.g. reliable delivery is disabled
+}
+
+// Filter of Query, empty fields match everything.
+type Filter struct {
+	Vins map[string]bool
+	From time.Time
+	To   time.Time
+}
+
+// Query reads the audit log and returns the matching decisions ordered by their first record.
+func Query(reader io.Reader, filter Filter) ([]*Decision, error) {
+	var decisions []*Decision
+	decisionsById := make(map[string]*Decision)
+	forwards := make(map[string]map[int]*Forward) // Forwards of every decision by their index
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 65536), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil || record.DecisionId == "" {
+			continue
+		}
+
+		decision, ok := decisionsById[record.DecisionId]
+		if !ok {
+			decision = &Decision{Id: record.DecisionId, Vin: record.Vin, Forwards: []*Forward{}}
+			decisionsById[record.DecisionId] = decision
+			forwards[record.DecisionId] = make(map[int]*Forward)
+			decisions = append(decisions, decision)
+		}
+
+		if record.Event == EventReceived {
+			decision.Received = &record
+			continue
+		}
+		// The vehicle may acknowledge before the forwarded record is written
+		forward, ok := forwards[record.DecisionId][record.Index]
+		if !ok {
+			forward = &Forward{}
+			forwards[record.DecisionId][record.Index] = forward
+			decision.Forwards = append(decision.Forwards, forward)
+		}
+		if record.Event == EventForwarded {
+			forward.Forwarded = &record
+		} else {
+			forward.Outcome = &record
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	matching := make([]*Decision, 0, len(decisions))
+	for _, decision := range decisions {
+		sort.SliceStable(decision.Forwards, func(i, j int) bool {
+			return decision.Forwards[i].index() < decision.Forwards[j].index()
+		})
+		if filter.matches(decision) {
+			matching = append(matching, decision)
+		}
+	}
+	return matching, nil
+}
+
+// matches filters by the VIN and the time the decision was received, or first forwarded if the receipt is not logged.
+func (filter *Filter) matches(decision *Decision) bool {
+	if len(filter.Vins) > 0 && !filter.Vins[decision.Vin] {
+		return false
+	}
+	first := decision.Received
+	if first == nil && len(decision.Forwards) > 0 {
+		first = decision.Forwards[0].Forwarded
+	}
+	if first == nil {
+		return filter.From.IsZero() && filter.To.IsZero()
+	}
+	at, err := time.Parse(api.TimestampFormat, first.Time)
+	if err != nil {
+		return false
+	}
+	if !filter.From.IsZero() && at.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && at.After(filter.To) {
+		return false
+	}
+	return true
+}
+
+func (forward *Forward) index() int {
+	if forward.Forwarded != nil {
+		return forward.Forwarded.Index
+	}
+	return forward.Outcome.Index
+}
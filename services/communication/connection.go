@@ -1,9 +1,12 @@
 package communication
 
 import (
-	"car-integration/services/redis"
+	"car-integration/models"
+	"car-integration/services/metrics"
+	"car-integration/services/routing"
 	"car-integration/services/statistics"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -16,12 +19,16 @@ import (
 type IConnection interface {
 	WriteDatagram(datagram api.IDatagram, safe bool)
 	ProcessDatagram(data []byte, safe bool)
-	OnDead(safe bool) // Called when the KeepAliveTimeout is reached before deletion of this connection.
+	OnDead(safe bool)                    // Called when the KeepAliveTimeout is reached before deletion of this connection.
+	Disconnect(reason string, safe bool) // Notifies the client that the Integration Module closes the connection.
 	GetKeepAliveTimeout(safe bool) float32
+	SetKeepAliveTimeout(timeout float32, safe bool)
 	GetClientAddress(safe bool) *net.UDPAddr
 
 	SetKeepAliveTimer(timer *time.Timer, safe bool)
 	GetKeepAliveTimer(safe bool) *time.Timer
+
+	GetSubscriptions(safe bool) []*Subscription // Subscriptions stop once the connection is dead, see Subscription.Done
 }
 
 /* Common Connection */
@@ -35,43 +42,103 @@ type Connection struct {
 	DataModel         *DataModel
 	KeepAliveTimeout  float32 // Seconds, after which is the connection discarded if no datagram arrived. 0 for no timeout
 	KeepAliveTimer    *time.Timer
+	Delivery          *ReliableDelivery // Retransmission of datagrams sent by WriteReliableDatagram, nil if disabled
+	Pinger            *Pinger           // Periodic pings measuring round trip time, nil if disabled
 }
 
 func (connection *Connection) WriteDatagram(datagram api.IDatagram, safe bool) {
-	//if safe {
-	connection.Lock()
-	defer connection.Unlock()
-	//}
+	connection.writeDatagram(datagram, nil, "", false, nil, nil, safe)
+}
+
+// WriteReliableDatagram sends the datagram to the address (nil for the client address) and retransmits it
+// until the client acknowledges it or a newer datagram of the same type and topic is sent.
+// Falls back to a single send if reliable delivery is not enabled for this connection.
+func (connection *Connection) WriteReliableDatagram(datagram api.IDatagram, address *net.UDPAddr, topic string, safe bool) {
+	connection.writeDatagram(datagram, address, topic, true, nil, nil, safe)
+}
+
+// WriteTrackedDatagram is WriteReliableDatagram reporting the outcome of the delivery to onDone.
+// Returns false if reliable delivery is not enabled, onDone is never called then.
+func (connection *Connection) WriteTrackedDatagram(datagram api.IDatagram, address *net.UDPAddr, topic string, onDone DeliveryCallback, safe bool) bool {
+	return connection.writeDatagram(datagram, address, topic, true, onDone, nil, safe)
+}
+
+// writeDatagram sends the datagram, beforeSend (may be nil) is called with its index before it is sent,
+// so a reply processed by the listener right after the send finds whatever beforeSend registered.
+func (connection *Connection) writeDatagram(datagram api.IDatagram, address *net.UDPAddr, topic string, reliable bool, onDone DeliveryCallback, beforeSend func(index int), safe bool) bool {
+	if safe {
+		connection.Lock()
+		defer connection.Unlock()
+	}
 
 	datagram.SetTimestamp(time.Now().UTC().Format(api.TimestampFormat))
 	datagram.SetIndex(connection.NextSendIndex)
 	connection.NextSendIndex++
+	if beforeSend != nil {
+		beforeSend(datagram.GetIndex())
+	}
 
 	data, err := json.Marshal(datagram)
 	if err != nil {
 		sentry.CaptureException(err)
 		fmt.Printf("Error marshalling datagram %v with error %v\n", datagram, err)
-		return
+		return false
 	}
 
-	// Send decision update to the vehicle
-	if safe == false {
-		connection.ClientAddress.Port = 12345
-		//TODO: Override the IP address to test the connection
-		// connection.ClientAddress.IP = net.IPv4(192, 168, 20, 38)
+	target := address
+	if target == nil {
+		target = connection.ClientAddress
 	}
-	_, err = connection.UDPConn.WriteToUDP(data, connection.ClientAddress)
+	tracked := reliable && connection.Delivery != nil
+	if tracked {
+		connection.Delivery.Track(datagram.GetIndex(), datagram.GetType(), topic, data, target, onDone, true)
+	}
+	_, err = connection.UDPConn.WriteToUDP(data, target)
 	if err != nil {
 		sentry.CaptureException(err)
 		fmt.Printf("Error writing datagram with error %v\n", err)
+		return tracked
+	}
+	metrics.DatagramSent(connection.LocalPort(), datagram.GetType())
+	return tracked
+}
+
+// Acknowledge matches the acknowledgement to an outstanding ping or datagram sent by WriteReliableDatagram.
+func (connection *Connection) Acknowledge(data []byte) {
+	receivedAt := time.Now()
+	if connection.Delivery == nil && connection.Pinger == nil {
 		return
 	}
-	if safe == false {
-		fmt.Printf("Sending message to %v: %s\n", connection.ClientAddress, data[:min(len(data), 2048)])
+	var acknowledgeDatagram api.AcknowledgeDatagram
+	err := json.Unmarshal(data, &acknowledgeDatagram)
+	if err != nil {
+		sentry.CaptureException(err)
+		fmt.Print("Parsing JSON failed: ", err)
+		return
+	}
+	if connection.Pinger != nil && connection.Pinger.Acknowledge(&acknowledgeDatagram, receivedAt) {
+		return
+	}
+	if connection.Delivery != nil {
+		connection.Delivery.Acknowledge(acknowledgeDatagram.AcknowledgingIndex, true)
+	}
+}
+
+// LocalPort returns the port of the Integration Module the connection communicates through.
+func (connection *Connection) LocalPort() int {
+	if address, ok := connection.UDPConn.LocalAddr().(*net.UDPAddr); ok {
+		return address.Port
 	}
+	return 0
 }
 
 func (connection *Connection) OnDead(safe bool) {
+	if connection.Delivery != nil {
+		connection.Delivery.Stop(true)
+	}
+	if connection.Pinger != nil {
+		connection.Pinger.Stop()
+	}
 }
 
 func (connection *Connection) GetKeepAliveTimeout(safe bool) float32 {
@@ -82,6 +149,14 @@ func (connection *Connection) GetKeepAliveTimeout(safe bool) float32 {
 	return connection.KeepAliveTimeout
 }
 
+func (connection *Connection) SetKeepAliveTimeout(timeout float32, safe bool) {
+	if safe {
+		connection.Lock()
+		defer connection.Unlock()
+	}
+	connection.KeepAliveTimeout = timeout
+}
+
 func (connection *Connection) GetClientAddress(safe bool) *net.UDPAddr {
 	if safe {
 		connection.Lock()
@@ -108,22 +183,46 @@ func (connection *Connection) SetKeepAliveTimer(timer *time.Timer, safe bool) {
 
 /* Connection from Processor */
 
+// DisconnectDatagram notifies a processor that the Integration Module closes the connection, e.g. on shutdown.
+type DisconnectDatagram struct {
+	api.BaseDatagram
+	Reason string `json:"reason"`
+}
+
 type ProcessorConnection struct {
 	Connection
 	Subscriptions map[string]*Subscription // Mapping content to subscription (only one subscription to each type can exist)
+	RoundTrip     *statistics.RoundTripStatistics
 }
 
 func (connection *ProcessorConnection) ProcessDatagram(data []byte, safe bool) {
+	connection.RoundTrip.Seen(time.Now())
+
 	// Parse data to JSON
 	var datagram api.BaseDatagram
 	err := json.Unmarshal(data, &datagram)
 	if err != nil {
 		sentry.CaptureException(err)
+		metrics.ParseFailure(connection.LocalPort())
 		fmt.Print("Parsing JSON failed.")
 		return
 	}
+	metrics.DatagramReceived(connection.LocalPort(), datagram.Type)
 
+	// Acknowledgements do not advance LastReceivedIndex, they are matched by the index they acknowledge
+	if datagram.Type == "acknowledge" {
+		connection.Acknowledge(data)
+		return
+	}
 	if datagram.Index <= connection.LastReceivedIndex {
+		// The acknowledgement of an accepted hand-off was lost, the neighbour retransmits until it gets one
+		if datagram.Type == "handoff_vehicle" {
+			response := &api.AcknowledgeDatagram{
+				BaseDatagram:       api.BaseDatagram{Type: "acknowledge"},
+				AcknowledgingIndex: datagram.Index,
+			}
+			connection.WriteDatagram(response, safe)
+		}
 		return
 	}
 
@@ -140,7 +239,7 @@ func (connection *ProcessorConnection) ProcessDatagram(data []byte, safe bool) {
 
 		// Used for subscriptions
 	case "subscribe":
-		var subscribeDatagram api.SubscribeDatagram
+		var subscribeDatagram SubscribeDatagram
 		_ = json.Unmarshal(data, &subscribeDatagram)
 
 		// Create subscription
@@ -185,6 +284,32 @@ func (connection *ProcessorConnection) ProcessDatagram(data []byte, safe bool) {
 		}
 		connection.WriteDatagram(response, safe)
 
+	// Vehicle handed off by the instance managing the neighbouring area
+	case "handoff_vehicle":
+		var handoffDatagram HandoffVehicleDatagram
+		err := json.Unmarshal(data, &handoffDatagram)
+		if err != nil {
+			sentry.CaptureException(err)
+			fmt.Print("Parsing JSON failed: ", err)
+			return
+		}
+
+		connection.DataModel.AcceptHandoff(&handoffDatagram, true)
+
+		response := &api.AcknowledgeDatagram{
+			BaseDatagram:       api.BaseDatagram{Type: "acknowledge"},
+			AcknowledgingIndex: handoffDatagram.Index,
+		}
+		connection.WriteDatagram(response, safe)
+
+		if safe {
+			connection.Lock()
+		}
+		connection.LastReceivedIndex = datagram.Index
+		if safe {
+			connection.Unlock()
+		}
+
 	case "decision_update":
 		var decisionUpdateDatagram api.UpdateVehicleDecisionDatagram
 		_ = json.Unmarshal(data, &decisionUpdateDatagram)
@@ -201,25 +326,20 @@ func (connection *ProcessorConnection) ProcessDatagram(data []byte, safe bool) {
 	}
 }
 
-func (connection *ProcessorConnection) Subscribe(datagram *api.SubscribeDatagram, safe bool) {
+func (connection *ProcessorConnection) Subscribe(datagram *SubscribeDatagram, safe bool) {
 	if safe {
 		connection.Lock()
 		defer connection.Unlock()
 	}
-	connection.Unsubscribe(datagram.Content, false) // Delete existing subscription if any
-	subscription := &Subscription{
-		&connection.Connection,
-		datagram.Content,
-		datagram.Topic,
-		datagram.Interval,
-		make(chan bool),
-	}
+	connection.unsubscribe(datagram.Content, ErrReplaced) // Delete existing subscription if any
+	subscription := NewSubscription(&connection.Connection, datagram.Content, datagram.Topic, datagram.Interval)
+	subscription.Replay = datagram.Replay
+	subscription.Overflow = datagram.Overflow
 	connection.Subscriptions[datagram.Content] = subscription
 	go func() {
 		err := subscription.Start()
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrUnsubscribed) && !errors.Is(err, ErrReplaced) && !errors.Is(err, ErrConnectionDead) {
 			sentry.CaptureException(err)
-			fmt.Printf("Subscription ended due to an error: %v\n", err)
 		}
 	}()
 }
@@ -229,25 +349,83 @@ func (connection *ProcessorConnection) Unsubscribe(content string, safe bool) {
 		connection.Lock()
 		defer connection.Unlock()
 	}
+	connection.unsubscribe(content, ErrUnsubscribed)
+}
+
+func (connection *ProcessorConnection) unsubscribe(content string, reason error) {
 	subscription, ok := connection.Subscriptions[content]
 	if ok {
-		go func() { subscription.Stop() }() // We have to call this in own coroutine because it may block, and would hold the connection lock
+		subscription.Stop(reason)
 		delete(connection.Subscriptions, content)
 	}
 }
 
-func (connection *ProcessorConnection) UnsubscribeAll(safe bool) {
+func (connection *ProcessorConnection) UnsubscribeAll(reason error, safe bool) {
 	if safe {
 		connection.Lock()
 		defer connection.Unlock()
 	}
 	for content := range connection.Subscriptions {
-		connection.Unsubscribe(content, false)
+		connection.unsubscribe(content, reason)
 	}
 }
 
+// GetSubscriptions returns the active subscriptions of the connection.
+func (connection *ProcessorConnection) GetSubscriptions(safe bool) []*Subscription {
+	if safe {
+		connection.Lock()
+		defer connection.Unlock()
+	}
+	subscriptions := make([]*Subscription, 0, len(connection.Subscriptions))
+	for _, subscription := range connection.Subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+// OnPingReply records the round trip of the ping.
+func (connection *ProcessorConnection) OnPingReply(reply PingReply) {
+	connection.RoundTrip.AddSample(reply.ReceivedAt.Sub(reply.SentAt))
+}
+
+// GetProcessorStatistics returns the round trip, loss and last-seen statistics of the processor.
+func (connection *ProcessorConnection) GetProcessorStatistics() ProcessorStatistics {
+	stats := connection.RoundTrip.GetStats()
+	processorStatistics := ProcessorStatistics{
+		Address:            connection.GetClientAddress(true).String(),
+		RoundTripTime:      int64(stats.RoundTripTime),
+		SmoothedRoundTrip:  int64(stats.SmoothedRoundTrip),
+		RoundTripVariation: int64(stats.RoundTripVariation),
+		MinRoundTrip:       int64(stats.MinRoundTrip),
+		MaxRoundTrip:       int64(stats.MaxRoundTrip),
+	}
+	if localAddress, ok := connection.UDPConn.LocalAddr().(*net.UDPAddr); ok {
+		processorStatistics.Port = localAddress.Port
+	}
+	if !stats.LastSeen.IsZero() {
+		processorStatistics.LastSeen = stats.LastSeen.UTC().Format(api.TimestampFormat)
+	}
+	if connection.Pinger != nil {
+		processorStatistics.PingsSent, processorStatistics.PingsReceived, processorStatistics.PingsLost = connection.Pinger.GetCounters()
+		if processorStatistics.PingsSent > 0 {
+			processorStatistics.LossRate = float64(processorStatistics.PingsLost) / float64(processorStatistics.PingsSent)
+		}
+	}
+	return processorStatistics
+}
+
+func (connection *ProcessorConnection) Disconnect(reason string, safe bool) {
+	datagram := &DisconnectDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "disconnect"},
+		Reason:       reason,
+	}
+	connection.WriteDatagram(datagram, safe)
+}
+
 func (connection *ProcessorConnection) OnDead(safe bool) {
-	connection.UnsubscribeAll(safe)
+	connection.DataModel.DeleteProcessor(connection, true)
+	connection.UnsubscribeAll(ErrConnectionDead, safe)
+	connection.Connection.OnDead(safe)
 }
 
 /* Connection from Vehicle */
@@ -257,6 +435,7 @@ type VehicleConnection struct {
 	VinNumber    string
 	Subscription *Subscription
 	NetworkStats *statistics.NetworkStatistics
+	HandedOffTo  string // Name of the neighbour the vehicle was handed off to, empty while it is managed here
 }
 
 func (connection *VehicleConnection) Subscribe(safe bool) {
@@ -264,34 +443,40 @@ func (connection *VehicleConnection) Subscribe(safe bool) {
 		connection.Lock()
 		defer connection.Unlock()
 	}
-	subscription := &Subscription{
-		&connection.Connection,
-		"decision-update",
-		connection.VinNumber,
-		1,
-		make(chan bool),
-	}
-
+	subscription := NewSubscription(&connection.Connection, "decision-update", connection.VinNumber, 1)
 	connection.Subscription = subscription
 
 	go func() {
 		err := subscription.Start()
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrConnectionDead) {
 			sentry.CaptureException(err)
-			fmt.Printf("Subscription ended due to an error: %v\n", err)
 		}
 	}()
 }
 
+// GetSubscriptions returns the decision updates subscription of the vehicle, if it was created.
+func (connection *VehicleConnection) GetSubscriptions(safe bool) []*Subscription {
+	if safe {
+		connection.Lock()
+		defer connection.Unlock()
+	}
+	if connection.Subscription == nil {
+		return nil
+	}
+	return []*Subscription{connection.Subscription}
+}
+
 func (connection *VehicleConnection) ProcessDatagram(data []byte, safe bool) {
 
 	// Parse data to JSON
 	var datagram api.BaseDatagram
 	err := json.Unmarshal(data, &datagram)
 	if err != nil {
+		metrics.ParseFailure(connection.LocalPort())
 		fmt.Print("Parsing JSON failed: ", err)
 		return
 	}
+	metrics.DatagramReceived(connection.LocalPort(), datagram.Type)
 	//if datagram.Index <= connection.LastReceivedIndex {
 	//	return
 	//}
@@ -306,11 +491,18 @@ func (connection *VehicleConnection) ProcessDatagram(data []byte, safe bool) {
 		}
 		connection.WriteDatagram(response, safe)
 
+	case "acknowledge":
+		connection.Acknowledge(data)
+
 	case "update_vehicle":
 		var updateVehicleDatagram api.UpdateVehicleDatagram
 		// DEBUG: Here are the data received from vehicle
 
 		_ = json.Unmarshal(data, &updateVehicleDatagram)
+		if !connection.DataModel.IsVinAllowed(updateVehicleDatagram.Vehicle.Vin, true) {
+			metrics.VehicleRejected()
+			break
+		}
 
 		// Continue with the rest of the parsing
 
@@ -324,8 +516,10 @@ func (connection *VehicleConnection) ProcessDatagram(data []byte, safe bool) {
 		}
 
 		connection.NetworkStats.Update(updateVehicleDatagram, time.Now().UTC())
-		// Save stats to Redis
-		err := redis.SaveNetworkStats(updateVehicleDatagram.Vehicle.Vin, &connection.NetworkStats.Stats)
+		stats := connection.NetworkStats.GetStats()
+		metrics.SetVehicleNetworkStats(updateVehicleDatagram.Vehicle.Vin, stats.AverageLatency, stats.Jitter, stats.LossRate)
+		// Save stats to the StatsStore, Redis by default
+		err := connection.DataModel.StatsStore.Save(updateVehicleDatagram.Vehicle.Vin, &stats)
 		if err != nil {
 			sentry.CaptureException(err)
 			fmt.Println("Failed to save network stats:", err)
@@ -339,17 +533,22 @@ func (connection *VehicleConnection) ProcessDatagram(data []byte, safe bool) {
 		// TODO: Debug here if needed
 		// fmt.Printf("Received vehicle data: %v\n", updateVehicleDatagram.Vehicle)
 
-		if true {
+		position := api.PositionJSON{
+			Lat: updateVehicleDatagram.Vehicle.Latitude,
+			Lon: updateVehicleDatagram.Vehicle.Longitude,
+		}
+		neighbour := connection.DataModel.FindHandoffNeighbour(&position, true)
+		if neighbour == nil {
+			if safe {
+				connection.Lock()
+			}
+			connection.HandedOffTo = ""
+			if safe {
+				connection.Unlock()
+			}
 			connection.DataModel.UpdateVehicle(connection, &updateVehicleDatagram, true)
 		} else {
-			// Disconnect vehicle which is outside the managed area
-			connection.DataModel.DeleteVehicle(updateVehicleDatagram.Vehicle.Vin, true)
-			response := &api.DisconnectVehicleDatagram{
-				BaseDatagram: api.BaseDatagram{Type: "disconnect_vehicle"},
-				ConnectTo:    "NOT IMPLEMENTED", // Should contain connection string to the following Integration Module, out of scope for now
-
-			}
-			connection.WriteDatagram(response, safe)
+			connection.HandOff(neighbour, &updateVehicleDatagram, safe)
 		}
 	}
 
@@ -362,6 +561,85 @@ func (connection *VehicleConnection) ProcessDatagram(data []byte, safe bool) {
 	}
 }
 
+// HandOff transfers the vehicle, which has left the managed area, to the neighbour
+// and tells the vehicle to reconnect to it.
+func (connection *VehicleConnection) HandOff(neighbour *models.Neighbour, datagram *api.UpdateVehicleDatagram, safe bool) {
+	if safe {
+		connection.Lock()
+	}
+	handedOff := connection.HandedOffTo == neighbour.Name
+	connection.HandedOffTo = neighbour.Name
+	if safe {
+		connection.Unlock()
+	}
+
+	// The vehicle keeps sending updates until it reconnects, transfer its state only once
+	if !handedOff {
+		connection.DataModel.UpdateVehicle(connection, datagram, true)
+		vehicle, decision, decisionReceivedAt := connection.DataModel.RemoveVehicle(datagram.Vehicle.Vin, GeofenceReasonHandedOff, true)
+		if vehicle != nil {
+			connection.DataModel.Handoff.TransferVehicle(neighbour, vehicle, decision, decisionReceivedAt)
+		}
+	}
+
+	response := &api.DisconnectVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "disconnect_vehicle"},
+		ConnectTo:    neighbour.VehicleEndpoint,
+	}
+	address := routing.Resolve(datagram.Vehicle.Vin, connection.GetClientAddress(safe))
+	connection.WriteReliableDatagram(response, address, datagram.Vehicle.Vin, safe)
+}
+
+// OnPingReply updates the clock offset estimate of the vehicle.
+func (connection *VehicleConnection) OnPingReply(reply PingReply) {
+	remoteTime, err := time.Parse(api.TimestampFormat, reply.RemoteTimestamp)
+	if err != nil {
+		fmt.Printf("Failed to parse ping acknowledgement timestamp %v\n", reply.RemoteTimestamp)
+		return
+	}
+	connection.NetworkStats.AddClockSample(reply.SentAt, remoteTime, reply.ReceivedAt)
+}
+
+// returnAddress returns the return address of the vehicle, the vehicle firmware may not listen on its source port.
+func (connection *VehicleConnection) returnAddress(safe bool) *net.UDPAddr {
+	if safe {
+		connection.Lock()
+		defer connection.Unlock()
+	}
+	if connection.VinNumber == "" {
+		return nil
+	}
+	return routing.Resolve(connection.VinNumber, connection.ClientAddress)
+}
+
+// Disconnect sends disconnect_vehicle without an instance to connect to, the vehicle reconnects once the module is back.
+func (connection *VehicleConnection) Disconnect(reason string, safe bool) {
+	datagram := &api.DisconnectVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "disconnect_vehicle"},
+	}
+	connection.writeDatagram(datagram, connection.returnAddress(safe), "", false, nil, nil, safe)
+}
+
 func (connection *VehicleConnection) OnDead(safe bool) {
-	connection.DataModel.DeleteVehicle(connection.VinNumber, true)
+	if safe {
+		connection.Lock()
+	}
+	if connection.Subscription != nil {
+		connection.Subscription.Stop(ErrConnectionDead)
+	}
+	if safe {
+		connection.Unlock()
+	}
+
+	// Final statistics of the vehicle
+	if connection.VinNumber != "" {
+		stats := connection.NetworkStats.GetStats()
+		err := connection.DataModel.StatsStore.Save(connection.VinNumber, &stats)
+		if err != nil {
+			fmt.Println("Failed to save network stats:", err)
+		}
+	}
+	connection.DataModel.DeleteVehicle(connection.VinNumber, GeofenceReasonDisconnected, true)
+	metrics.DeleteVehicle(connection.VinNumber)
+	connection.Connection.OnDead(safe)
 }
@@ -1,7 +1,10 @@
 package communication
 
 import (
+	"car-integration/services/metrics"
 	"car-integration/services/statistics"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -19,8 +22,23 @@ type ConnectionsManager struct {
 	ConnectionType   string
 	KeepAliveTimeout float32
 	Logger           *zerolog.Logger
+	ReliableDelivery *ReliableDeliveryOptions // Enables retransmission of unacknowledged datagrams for new connections, nil to disable
+	PingInterval     time.Duration            // Interval of pings measuring round trip and vehicle clock offset, 0 to disable
+	Port             int                      // Port the manager listens on, set by StartListening
+	State            string                   // ListenerStarting, ListenerListening or ListenerFailed
+	ListenError      error                    // Reason of ListenerFailed
+	UDPConn          *net.UDPConn             // Socket of the listener, set by StartListening
 }
 
+// States of the UDP listener of a ConnectionsManager
+const (
+	ListenerStarting  = "starting"
+	ListenerListening = "listening"
+	ListenerFailed    = "failed"
+	ListenerDraining  = "draining" // Shutdown in progress, received datagrams are dropped
+	ListenerStopped   = "stopped"
+)
+
 // NewConnectionsManager creates Connection Manager, connectionType can be "processor" or "vehicle"
 // You can log the incoming messages by providing filepath via inputLogFilepath, leave nil to disable logging
 func NewConnectionsManager(dataModel *DataModel, connectionType string, keepAliveTimeout float32, inputLogFilepath *string) *ConnectionsManager {
@@ -41,6 +59,7 @@ func NewConnectionsManager(dataModel *DataModel, connectionType string, keepAliv
 		ConnectionType:   connectionType,
 		KeepAliveTimeout: keepAliveTimeout,
 		Logger:           logger,
+		State:            ListenerStarting,
 	}
 }
 
@@ -49,6 +68,21 @@ func (manager *ConnectionsManager) StartListening(port int, safe bool, ip string
 	serverAddress := net.UDPAddr{Port: port, IP: net.ParseIP(ip)}
 	conn, err := net.ListenUDP("udp", &serverAddress)
 
+	if safe {
+		manager.Lock()
+	}
+	manager.Port = port
+	if err != nil {
+		manager.State = ListenerFailed
+		manager.ListenError = err
+	} else {
+		manager.State = ListenerListening
+		manager.UDPConn = conn
+	}
+	if safe {
+		manager.Unlock()
+	}
+
 	if err != nil {
 		sentry.CaptureException(err)
 		fmt.Printf("Error initializing UDP server: %v\n", err)
@@ -60,10 +94,23 @@ func (manager *ConnectionsManager) StartListening(port int, safe bool, ip string
 	// Datagram reading loop
 	for {
 		readBufferLength, clientAddress, err := conn.ReadFromUDP(readBuffer)
+		if errors.Is(err, net.ErrClosed) {
+			if safe {
+				manager.Lock()
+			}
+			manager.State = ListenerStopped
+			if safe {
+				manager.Unlock()
+			}
+			fmt.Printf("Server on port %v stopped\n", port)
+			return
+		}
+
 		if safe {
 			manager.Lock()
 		}
 		connectionType := manager.ConnectionType
+		draining := manager.State == ListenerDraining
 		if safe {
 			manager.Unlock()
 		}
@@ -75,6 +122,9 @@ func (manager *ConnectionsManager) StartListening(port int, safe bool, ip string
 			fmt.Printf("Error reading message  %v\n", err)
 			continue
 		}
+		if draining {
+			continue
+		}
 
 		data := readBuffer[:readBufferLength]
 		var connection = manager.GetOrCreateConnection(conn, clientAddress, safe)
@@ -105,9 +155,14 @@ func (manager *ConnectionsManager) GetOrCreateConnection(conn *net.UDPConn, addr
 	var addrString = addr.String()
 	connection, ok := manager.Connections[addrString]
 	if !ok {
+		var delivery *ReliableDelivery
+		if manager.ReliableDelivery != nil {
+			delivery = NewReliableDelivery(conn, *manager.ReliableDelivery)
+		}
+
 		switch manager.ConnectionType {
 		case "processor":
-			connection = &ProcessorConnection{
+			processorConnection := &ProcessorConnection{
 				Connection: Connection{
 					UDPConn:           conn,
 					ClientAddress:     addr,
@@ -115,11 +170,20 @@ func (manager *ConnectionsManager) GetOrCreateConnection(conn *net.UDPConn, addr
 					LastReceivedIndex: -1,
 					DataModel:         manager.DataModel,
 					KeepAliveTimeout:  manager.KeepAliveTimeout,
+					Delivery:          delivery,
 				},
 				Subscriptions: make(map[string]*Subscription),
+				RoundTrip:     statistics.NewRoundTripStatistics(),
 			}
+			if manager.PingInterval > 0 {
+				processorConnection.Pinger = NewPinger(&processorConnection.Connection, manager.PingInterval,
+					nil, processorConnection.OnPingReply)
+				go processorConnection.Pinger.Start()
+			}
+			manager.DataModel.AddProcessor(processorConnection, true)
+			connection = processorConnection
 		case "vehicle":
-			connection = &VehicleConnection{
+			vehicleConnection := &VehicleConnection{
 				Connection: Connection{
 					UDPConn:           conn,
 					ClientAddress:     addr,
@@ -127,24 +191,126 @@ func (manager *ConnectionsManager) GetOrCreateConnection(conn *net.UDPConn, addr
 					LastReceivedIndex: -1,
 					DataModel:         manager.DataModel,
 					KeepAliveTimeout:  manager.KeepAliveTimeout,
+					Delivery:          delivery,
 				},
 				NetworkStats: statistics.NewNetworkStatistics(),
 			}
+			if manager.PingInterval > 0 {
+				vehicleConnection.Pinger = NewPinger(&vehicleConnection.Connection, manager.PingInterval,
+					func() *net.UDPAddr { return vehicleConnection.returnAddress(true) }, vehicleConnection.OnPingReply)
+				go vehicleConnection.Pinger.Start()
+			}
+			connection = vehicleConnection
 		default:
 			return nil
 		}
 		manager.Connections[addrString] = connection
+		metrics.SetActiveConnections(manager.Port, manager.ConnectionType, len(manager.Connections))
 	}
 	return connection
 }
 
+// SetKeepAliveTimeout changes the keep alive timeout of the manager and its live connections.
+// Running timers are re-armed with the new timeout by the next datagram, or stopped if the keep alive is disabled.
+func (manager *ConnectionsManager) SetKeepAliveTimeout(timeout float32, safe bool) {
+	if safe {
+		manager.Lock()
+		defer manager.Unlock()
+	}
+	manager.KeepAliveTimeout = timeout
+	for _, connection := range manager.Connections {
+		connection.SetKeepAliveTimeout(timeout, true)
+		if timer := connection.GetKeepAliveTimer(true); timer != nil && timeout <= 0 {
+			timer.Stop()
+		}
+	}
+}
+
+// GetListenerState returns the port, state of the UDP listener and the error it failed with.
+func (manager *ConnectionsManager) GetListenerState(safe bool) (int, string, error) {
+	if safe {
+		manager.Lock()
+		defer manager.Unlock()
+	}
+	return manager.Port, manager.State, manager.ListenError
+}
+
+// GetConnections returns the current connections of the manager.
+func (manager *ConnectionsManager) GetConnections(safe bool) []IConnection {
+	if safe {
+		manager.Lock()
+		defer manager.Unlock()
+	}
+	connections := make([]IConnection, 0, len(manager.Connections))
+	for _, connection := range manager.Connections {
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+// GetConnection returns the connection from the address, nil if there is none.
+func (manager *ConnectionsManager) GetConnection(address string, safe bool) IConnection {
+	if safe {
+		manager.Lock()
+		defer manager.Unlock()
+	}
+	return manager.Connections[address]
+}
+
 func (manager *ConnectionsManager) DeleteConnection(connection IConnection, safe bool) {
 	if safe {
 		manager.Lock()
 		defer manager.Unlock()
 	}
+	// The keep alive timer may fire while the connection is being deleted by the admin API or shutdown
+	address := connection.GetClientAddress(true).String()
+	if manager.Connections[address] != connection {
+		return
+	}
 	connection.OnDead(true)
-	delete(manager.Connections, connection.GetClientAddress(true).String())
+	delete(manager.Connections, address)
+	metrics.SetActiveConnections(manager.Port, manager.ConnectionType, len(manager.Connections))
+}
+
+// Shutdown stops accepting datagrams, notifies every client that the connection is being closed,
+// deletes the connections and closes the listener once their subscriptions finished.
+// Returns the error of the context if the subscriptions did not finish in time, the listener is closed anyway.
+func (manager *ConnectionsManager) Shutdown(ctx context.Context, reason string) error {
+	manager.Lock()
+	if manager.State != ListenerListening {
+		manager.Unlock()
+		return nil
+	}
+	manager.State = ListenerDraining
+	conn := manager.UDPConn
+	manager.Unlock()
+
+	var subscriptions []*Subscription
+	for _, connection := range manager.GetConnections(true) {
+		if timer := connection.GetKeepAliveTimer(true); timer != nil {
+			timer.Stop()
+		}
+		subscriptions = append(subscriptions, connection.GetSubscriptions(true)...)
+		connection.Disconnect(reason, true)
+		manager.DeleteConnection(connection, true)
+	}
+
+	var err error
+	for _, subscription := range subscriptions {
+		select {
+		case <-subscription.Done():
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+
+	closeErr := conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
 
 func (manager *ConnectionsManager) LogInput(message string, clientAddress *net.UDPAddr, port int, connectionType string) {
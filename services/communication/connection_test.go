@@ -0,0 +1,120 @@
+package communication
+
+import (
+	"car-integration/models"
+	"car-integration/services/statistics"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// newTestProcessorConnection returns a processor connection of the module and the socket of its client, both on loopback.
+func newTestProcessorConnection(t *testing.T, dataModel *DataModel) (*ProcessorConnection, *net.UDPConn) {
+	t.Helper()
+	module, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = module.Close()
+		_ = client.Close()
+	})
+
+	connection := &ProcessorConnection{
+		Connection: Connection{
+			UDPConn:           module,
+			ClientAddress:     client.LocalAddr().(*net.UDPAddr),
+			NextSendIndex:     1,
+			LastReceivedIndex: -1,
+			DataModel:         dataModel,
+		},
+		Subscriptions: make(map[string]*Subscription),
+		RoundTrip:     statistics.NewRoundTripStatistics(),
+	}
+	return connection, client
+}
+
+// readAcknowledgement reads the next datagram of the client and returns the index it acknowledges.
+func readAcknowledgement(t *testing.T, client *net.UDPConn) int {
+	t.Helper()
+	buffer := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("no acknowledgement: %v", err)
+	}
+	var datagram api.AcknowledgeDatagram
+	err = json.Unmarshal(buffer[:n], &datagram)
+	if err != nil || datagram.Type != "acknowledge" {
+		t.Fatalf("expected acknowledgement, got %s", buffer[:n])
+	}
+	return datagram.AcknowledgingIndex
+}
+
+func TestRetransmittedHandoffIsAcknowledgedAgain(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	connection, client := newTestProcessorConnection(t, dataModel)
+
+	datagram := &HandoffVehicleDatagram{
+		BaseDatagram:     api.BaseDatagram{Type: "handoff_vehicle", Index: 7},
+		Vehicle:          api.UpdateVehicleVehicle{Vin: "VIN1"},
+		VehicleTimestamp: "2026-01-01T00:00:00.000Z",
+	}
+	data, _ := json.Marshal(datagram)
+
+	connection.ProcessDatagram(data, true)
+	if index := readAcknowledgement(t, client); index != 7 {
+		t.Fatalf("acknowledged %v, expected 7", index)
+	}
+	// The first acknowledgement is lost, the neighbour retransmits the same datagram
+	dataModel.RemoveVehicle("VIN1", GeofenceReasonHandedOff, true)
+	connection.ProcessDatagram(data, true)
+	if index := readAcknowledgement(t, client); index != 7 {
+		t.Fatalf("acknowledged %v, expected 7", index)
+	}
+	if dataModel.GetVehicleCount(true) != 0 {
+		t.Fatal("retransmitted hand-off was accepted again")
+	}
+}
+
+func TestHandoffKeepsNewerDecision(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	updateDecision(dataModel, "VIN1", "local")
+	receivedAt := dataModel.VehicleDecisionReceivedAt["VIN1"]
+
+	tests := []struct {
+		name              string
+		decisionTimestamp string
+		want              string
+	}{
+		{"older handed off decision", receivedAt.Add(-time.Second).UTC().Format(api.TimestampFormat), "local"},
+		{"handed off decision without timestamp", "", "local"},
+		{"newer handed off decision", receivedAt.Add(time.Second).UTC().Format(api.TimestampFormat), "handed off"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generation := dataModel.DecisionGenerations["VIN1"]
+			dataModel.AcceptHandoff(&HandoffVehicleDatagram{
+				BaseDatagram:      api.BaseDatagram{Type: "handoff_vehicle"},
+				Vehicle:           api.UpdateVehicleVehicle{Vin: "VIN1"},
+				VehicleTimestamp:  time.Now().UTC().Format(api.TimestampFormat),
+				VehicleDecision:   &api.UpdateVehicleDecision{Vin: "VIN1", Message: "handed off"},
+				DecisionTimestamp: test.decisionTimestamp,
+			}, true)
+
+			if message := dataModel.VehicleDecisions["VIN1"].Message; message != test.want {
+				t.Errorf("decision %q, expected %q", message, test.want)
+			}
+			if advanced := dataModel.DecisionGenerations["VIN1"] != generation; advanced != (test.want != "local") {
+				t.Errorf("decision generation advanced %v, expected %v", advanced, test.want != "local")
+			}
+		})
+	}
+}
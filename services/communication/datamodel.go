@@ -2,8 +2,14 @@ package communication
 
 import (
 	"car-integration/models"
+	audit "car-integration/services/audit"
+	database "car-integration/services/database"
+	"car-integration/services/metrics"
+	statistics "car-integration/services/statistics"
 	"fmt"
 	"log"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -22,39 +28,78 @@ func ParseTime(timestamp string) time.Time {
 
 type DataModel struct {
 	sync.Mutex
-	Area                   *models.Area
-	Vehicles               map[string]*Vehicle
-	VehicleDecisions       map[string]*api.UpdateVehicleDecision
-	NextVehicleId          int
-	VehicleConnectionsById map[int]*VehicleConnection
-	Notifications          map[int]map[string]*Notification
-	NotificationDuration   float32
-	NextNotificationId     int
+	Area                      *models.Area
+	Vehicles                  map[string]*Vehicle
+	VehicleDecisions          map[string]*api.UpdateVehicleDecision
+	VehicleDecisionReceivedAt map[string]time.Time
+	VehicleDecisionIds        map[string]string     // Ids of the current decisions in the DecisionLog
+	DecisionLog               *audit.DecisionLog    // Audit log of received and forwarded decisions, nil if disabled
+	StatsStore                statistics.StatsStore // Network statistics of the vehicles
+	NextVehicleId             int
+	VehicleConnectionsById    map[int]*VehicleConnection
+	Notifications             map[int]map[string]*Notification
+	NotificationDuration      float32
+	NextNotificationId        int
+	Handoff                   *Handoff // Hand-off of vehicles leaving the Area to neighbouring instances, nil if disabled
+	Geofence                  *GeofenceTracker
+	History                   map[string]*VehicleHistory
+	HistoryDepth              int                   // Maximum number of updates kept per vehicle
+	HistoryDuration           time.Duration         // Maximum age of updates kept per vehicle, 0 for no limit
+	UpdateQueues              map[*UpdateQueue]bool // Queues of the live updates subscribers
+	UpdateQueueCapacity       int
+	UpdateQueueOverflow       string                        // Default overflow policy of the queues, OverflowCoalesce or OverflowDropOldest
+	Processors                map[*ProcessorConnection]bool // Processor connections of all ConnectionsManagers
+	LastVehicleUpdateAt       time.Time                     // Time the last vehicle update was accepted
+	AllowedVins               map[string]bool               // VINs of vehicles accepted by the module, nil to accept all
 
-	updateCond                *sync.Cond
-	updateCondDecision        *sync.Cond
-	UpdatedVehicleVin         string
-	UpdatedVehicleDecisionVin string
+	updateCondDecision  *sync.Cond
+	updateCondGeofence  *sync.Cond
+	UpdatedVehicleVin   string
+	DecisionGenerations map[string]int // Incremented per VIN with every decision update, kept after a disconnect or hand-off so it never repeats, dropped once the VIN is not allowed
 }
 
 func NewDataModel(area *models.Area, notificationDuration float32) *DataModel {
 	dm := &DataModel{
-		Area:                   area,
-		Vehicles:               make(map[string]*Vehicle),
-		VehicleDecisions:       make(map[string]*api.UpdateVehicleDecision),
-		Notifications:          make(map[int]map[string]*Notification),
-		VehicleConnectionsById: make(map[int]*VehicleConnection)}
-	dm.updateCond = sync.NewCond(&dm.Mutex)
+		Area:                      area,
+		Vehicles:                  make(map[string]*Vehicle),
+		VehicleDecisions:          make(map[string]*api.UpdateVehicleDecision),
+		VehicleDecisionReceivedAt: make(map[string]time.Time),
+		VehicleDecisionIds:        make(map[string]string),
+		DecisionGenerations:       make(map[string]int),
+		StatsStore:                statistics.NewMemoryStore(0),
+		Notifications:             make(map[int]map[string]*Notification),
+		VehicleConnectionsById:    make(map[int]*VehicleConnection),
+		Geofence:                  NewGeofenceTracker(3, 1024),
+		History:                   make(map[string]*VehicleHistory),
+		HistoryDepth:              1200,
+		HistoryDuration:           60 * time.Second,
+		UpdateQueues:              make(map[*UpdateQueue]bool),
+		UpdateQueueCapacity:       256,
+		UpdateQueueOverflow:       OverflowCoalesce,
+		Processors:                make(map[*ProcessorConnection]bool)}
 	dm.updateCondDecision = sync.NewCond(&dm.Mutex)
+	dm.updateCondGeofence = sync.NewCond(&dm.Mutex)
 	return dm
 }
 
+// UpdateVehicle stores the vehicle update unless it is older than the stored one.
+// Accepted updates are queued for the telemetry storage after the DataModel is unlocked.
 func (dataModel *DataModel) UpdateVehicle(connection *VehicleConnection, datagram *api.UpdateVehicleDatagram, safe bool) {
 	if safe {
 		dataModel.Lock()
-		defer dataModel.Unlock()
 	}
+	accepted := dataModel.updateVehicle(connection, datagram)
+	receivedAt := dataModel.LastVehicleUpdateAt
+	if safe {
+		dataModel.Unlock()
+	}
+
+	if accepted {
+		database.WriteTelemetry(&datagram.Vehicle, datagram.Timestamp, receivedAt)
+	}
+}
 
+func (dataModel *DataModel) updateVehicle(connection *VehicleConnection, datagram *api.UpdateVehicleDatagram) bool {
 	vehicle := datagram.Vehicle
 
 	savedVehicle, ok := dataModel.Vehicles[vehicle.Vin]
@@ -70,27 +115,52 @@ func (dataModel *DataModel) UpdateVehicle(connection *VehicleConnection, datagra
 		if err != nil {
 			sentry.CaptureException(err)
 			fmt.Printf("Failed to parse %v\n", datagram.Timestamp)
-			return
+			return false
 		}
 
 		lastTime, err := time.Parse(api.TimestampFormat, savedVehicle.Timestamp)
 		if err != nil {
 			sentry.CaptureException(err)
 			fmt.Printf("Failed to parse %v\n", savedVehicle.Timestamp)
-			return
+			return false
 		}
 
-		// We want to discard the received datagram if it was older than current data we have
-		if newTime.Before(lastTime) {
-			return
+		// We want to discard the received datagram if it was older than current data we have.
+		// Restored data may come from before a restart of the vehicle, its clock may have been reset.
+		if newTime.Before(lastTime) && !savedVehicle.Stale {
+			return false
 		}
 	}
 
 	savedVehicle.UpdateVehicleVehicle = vehicle
+	savedVehicle.Timestamp = datagram.Timestamp
+	savedVehicle.Stale = false
+	savedVehicle.RestoredAt = ""
 
 	dataModel.VehicleConnectionsById[savedVehicle.Id] = connection
+	dataModel.LastVehicleUpdateAt = time.Now()
+
+	history, ok := dataModel.History[vehicle.Vin]
+	if !ok {
+		history = NewVehicleHistory(dataModel.HistoryDepth, dataModel.HistoryDuration)
+		dataModel.History[vehicle.Vin] = history
+	}
+	history.Push(HistoryEntry{
+		ReceivedAt: time.Now(),
+		Timestamp:  datagram.Timestamp,
+		Vehicle:    vehicle,
+	})
+
 	dataModel.UpdatedVehicleVin = vehicle.Vin
-	dataModel.updateCond.Broadcast()
+	for queue := range dataModel.UpdateQueues {
+		queue.Push(vehicle)
+	}
+
+	position := api.PositionJSON{Lat: vehicle.Latitude, Lon: vehicle.Longitude}
+	if dataModel.Geofence.Update(vehicle.Vin, dataModel.Area.Zones, position, datagram.Timestamp) > 0 {
+		dataModel.updateCondGeofence.Broadcast()
+	}
+	return true
 }
 
 func (dataModel *DataModel) UpdateVehicleDecision(connection *ProcessorConnection, datagram *api.UpdateVehicleDecisionDatagram, safe bool) {
@@ -127,18 +197,273 @@ func (dataModel *DataModel) UpdateVehicleDecision(connection *ProcessorConnectio
 		Vin:     vehicleDecision.Vin,
 	}
 	dataModel.VehicleDecisions[savedVehicle.Vin] = savedVehicle
+	dataModel.VehicleDecisionReceivedAt[savedVehicle.Vin] = time.Now()
+	dataModel.auditDecision(connection, datagram)
 
-	dataModel.UpdatedVehicleDecisionVin = savedVehicle.Vin
+	dataModel.DecisionGenerations[savedVehicle.Vin]++
 	dataModel.updateCondDecision.Broadcast()
 }
 
+// auditDecision records the received decision with the vehicle state it was based on in the DecisionLog.
+func (dataModel *DataModel) auditDecision(connection *ProcessorConnection, datagram *api.UpdateVehicleDecisionDatagram) {
+	if dataModel.DecisionLog == nil {
+		return
+	}
+	vin := datagram.VehicleDecision.Vin
+	id := dataModel.DecisionLog.NewId()
+	dataModel.VehicleDecisionIds[vin] = id
+
+	record := audit.Record{
+		Event:           audit.EventReceived,
+		DecisionId:      id,
+		Vin:             vin,
+		Time:            dataModel.VehicleDecisionReceivedAt[vin].UTC().Format(api.TimestampFormat),
+		Message:         datagram.VehicleDecision.Message,
+		SourceIndex:     datagram.Index,
+		SourceTimestamp: datagram.Timestamp,
+	}
+	// Decisions injected through the admin API have no processor connection
+	if connection == nil {
+		record.Source = "admin"
+	} else if address := connection.GetClientAddress(true); address != nil {
+		record.Source = address.String()
+	}
+	if vehicle, ok := dataModel.Vehicles[vin]; ok {
+		telemetry := vehicle.UpdateVehicleVehicle
+		record.Telemetry = &telemetry
+		record.TelemetryTimestamp = vehicle.Timestamp
+	}
+	dataModel.DecisionLog.Write(record)
+}
+
 // DeleteVehicle removes the vehicle identified by the vin number from the DataModel.
-func (dataModel *DataModel) DeleteVehicle(vin string, safe bool) {
+// The vehicle exits the zones it is inside of with the reason, see GeofenceTracker.Forget.
+func (dataModel *DataModel) DeleteVehicle(vin string, reason string, safe bool) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	dataModel.forgetGeofence(vin, reason)
+	delete(dataModel.Vehicles, vin)
+	delete(dataModel.History, vin)
+}
+
+// forgetGeofence drops the geofence state of the vehicle, which has to be still in the DataModel for its last position.
+func (dataModel *DataModel) forgetGeofence(vin string, reason string) {
+	var position api.PositionJSON
+	if vehicle, ok := dataModel.Vehicles[vin]; ok {
+		position = api.PositionJSON{Lat: vehicle.Latitude, Lon: vehicle.Longitude}
+	}
+	timestamp := time.Now().UTC().Format(api.TimestampFormat)
+	if dataModel.Geofence.Forget(vin, dataModel.Area.Zones, reason, position, timestamp) > 0 {
+		dataModel.updateCondGeofence.Broadcast()
+	}
+}
+
+func (dataModel *DataModel) GetArea(safe bool) *models.Area {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	return dataModel.Area
+}
+
+// IsVinAllowed reports whether updates of the vehicle are accepted, see AllowedVins.
+func (dataModel *DataModel) IsVinAllowed(vin string, safe bool) bool {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	return dataModel.AllowedVins == nil || dataModel.AllowedVins[vin]
+}
+
+// Reconfigure replaces the area and the allowed VINs (empty to accept all) at once
+// and removes the vehicles which are no longer allowed with their decisions. Vehicles inside of zones
+// missing in the new area exit them, see GeofenceTracker.ForgetZones. Returns VINs of the removed vehicles and names of the removed zones.
+func (dataModel *DataModel) Reconfigure(area *models.Area, allowedVins []string, safe bool) ([]string, []string) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	dataModel.AllowedVins = nil
+	if len(allowedVins) > 0 {
+		dataModel.AllowedVins = make(map[string]bool, len(allowedVins))
+		for _, vin := range allowedVins {
+			dataModel.AllowedVins[vin] = true
+		}
+	}
+
+	// Vehicles are removed while the old area is set, so their exit events have the kinds of its zones
+	var removedVehicles []string
+	for vin := range dataModel.Vehicles {
+		if !dataModel.IsVinAllowed(vin, false) {
+			dataModel.RemoveVehicle(vin, GeofenceReasonRemoved, false)
+			delete(dataModel.DecisionGenerations, vin)
+			metrics.DeleteVehicle(vin)
+			removedVehicles = append(removedVehicles, vin)
+		}
+	}
+	if len(removedVehicles) > 0 {
+		// Subscriptions of the removed vehicles see the generation reset and wait for a new decision
+		dataModel.updateCondDecision.Broadcast()
+	}
+	sort.Strings(removedVehicles)
+
+	var removedZones []string
+	var zones []models.Zone
+	if dataModel.Area != nil {
+		for _, zone := range dataModel.Area.Zones {
+			if !slices.ContainsFunc(area.Zones, func(newZone models.Zone) bool { return newZone.Name == zone.Name }) {
+				zones = append(zones, zone)
+				removedZones = append(removedZones, zone.Name)
+			}
+		}
+	}
+	if len(zones) > 0 {
+		positions := make(map[string]api.PositionJSON, len(dataModel.Vehicles))
+		for vin, vehicle := range dataModel.Vehicles {
+			positions[vin] = api.PositionJSON{Lat: vehicle.Latitude, Lon: vehicle.Longitude}
+		}
+		timestamp := time.Now().UTC().Format(api.TimestampFormat)
+		if dataModel.Geofence.ForgetZones(zones, GeofenceReasonZoneRemoved, positions, timestamp) > 0 {
+			dataModel.updateCondGeofence.Broadcast()
+		}
+	}
+	sort.Strings(removedZones)
+
+	dataModel.Area = area
+	return removedVehicles, removedZones
+}
+
+// FindHandoffNeighbour returns the neighbour the vehicle at the position should be handed off to.
+// Returns nil if hand-off is disabled, the position is inside the managed area, or no neighbour manages it.
+func (dataModel *DataModel) FindHandoffNeighbour(position *api.PositionJSON, safe bool) *models.Neighbour {
 	if safe {
 		dataModel.Lock()
 		defer dataModel.Unlock()
 	}
+
+	if dataModel.Handoff == nil || dataModel.Area.Contains(position) {
+		return nil
+	}
+	return dataModel.Handoff.FindNeighbour(position, true)
+}
+
+// RemoveVehicle removes the vehicle and its decision from the DataModel and returns them with the time the decision was received.
+// The vehicle exits the zones it is inside of with the reason, see GeofenceTracker.Forget.
+func (dataModel *DataModel) RemoveVehicle(vin string, reason string, safe bool) (*Vehicle, *api.UpdateVehicleDecision, time.Time) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	dataModel.forgetGeofence(vin, reason)
+
+	vehicle := dataModel.Vehicles[vin]
+	decision := dataModel.VehicleDecisions[vin]
+	decisionReceivedAt := dataModel.VehicleDecisionReceivedAt[vin]
 	delete(dataModel.Vehicles, vin)
+	delete(dataModel.VehicleDecisions, vin)
+	delete(dataModel.VehicleDecisionReceivedAt, vin)
+	delete(dataModel.VehicleDecisionIds, vin)
+	delete(dataModel.History, vin)
+	return vehicle, decision, decisionReceivedAt
+}
+
+// AcceptHandoff stores the state of a vehicle handed off by a neighbouring instance.
+// The vehicle state and the decision are each kept if the stored one is newer, probably received directly.
+// The vehicle connection is assigned once the vehicle itself connects.
+func (dataModel *DataModel) AcceptHandoff(datagram *HandoffVehicleDatagram, safe bool) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	vehicle := datagram.Vehicle
+	savedVehicle, ok := dataModel.Vehicles[vehicle.Vin]
+	if !ok || ParseTime(datagram.VehicleTimestamp).After(ParseTime(savedVehicle.Timestamp)) {
+		if !ok {
+			dataModel.NextVehicleId++
+		}
+		dataModel.Vehicles[vehicle.Vin] = &Vehicle{
+			UpdateVehicleVehicle: vehicle,
+			Timestamp:            datagram.VehicleTimestamp,
+		}
+	}
+
+	if datagram.VehicleDecision == nil {
+		return
+	}
+	// Neighbours not sending the decision timestamp lose against a stored decision
+	receivedAt := time.Now()
+	if datagram.DecisionTimestamp != "" {
+		receivedAt = ParseTime(datagram.DecisionTimestamp)
+	}
+	if _, ok := dataModel.VehicleDecisions[vehicle.Vin]; ok &&
+		(datagram.DecisionTimestamp == "" || !receivedAt.After(dataModel.VehicleDecisionReceivedAt[vehicle.Vin])) {
+		return
+	}
+	dataModel.VehicleDecisions[vehicle.Vin] = datagram.VehicleDecision
+	dataModel.VehicleDecisionReceivedAt[vehicle.Vin] = receivedAt
+	delete(dataModel.VehicleDecisionIds, vehicle.Vin) // The id belongs to the replaced local decision
+
+	dataModel.DecisionGenerations[vehicle.Vin]++
+	dataModel.updateCondDecision.Broadcast()
+}
+
+// GetVehicleStates returns copies of the vehicles and decisions by VIN.
+func (dataModel *DataModel) GetVehicleStates(safe bool) (map[string]Vehicle, map[string]api.UpdateVehicleDecision) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	vehicles := make(map[string]Vehicle, len(dataModel.Vehicles))
+	for vin, vehicle := range dataModel.Vehicles {
+		vehicles[vin] = *vehicle
+	}
+	decisions := make(map[string]api.UpdateVehicleDecision, len(dataModel.VehicleDecisions))
+	for vin, decision := range dataModel.VehicleDecisions {
+		decisions[vin] = *decision
+	}
+	return vehicles, decisions
+}
+
+func (dataModel *DataModel) GetLastVehicleUpdateAt(safe bool) time.Time {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	return dataModel.LastVehicleUpdateAt
+}
+
+func (dataModel *DataModel) GetVehicleCount(safe bool) int {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	return len(dataModel.Vehicles)
+}
+
+// GetStaleVehicles returns the restored vehicles which did not send an update since the restore.
+func (dataModel *DataModel) GetStaleVehicles(safe bool) []StaleVehicle {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	var stale []StaleVehicle
+	for _, vehicle := range dataModel.Vehicles {
+		if vehicle.Stale {
+			stale = append(stale, StaleVehicle{
+				Vin:        vehicle.Vin,
+				Timestamp:  vehicle.Timestamp,
+				RestoredAt: vehicle.RestoredAt,
+			})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Vin < stale[j].Vin })
+	return stale
 }
 
 func (dataModel *DataModel) GetVehicles(safe bool) []api.UpdateVehicleVehicle {
@@ -166,6 +491,86 @@ func (dataModel *DataModel) GetVehicles(safe bool) []api.UpdateVehicleVehicle {
 	return vehicles
 }
 
+func (dataModel *DataModel) AddProcessor(connection *ProcessorConnection, safe bool) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	dataModel.Processors[connection] = true
+}
+
+func (dataModel *DataModel) DeleteProcessor(connection *ProcessorConnection, safe bool) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	delete(dataModel.Processors, connection)
+}
+
+// GetProcessorStatistics returns statistics of all connected processors.
+func (dataModel *DataModel) GetProcessorStatistics(safe bool) []ProcessorStatistics {
+	if safe {
+		dataModel.Lock()
+	}
+	processors := make([]*ProcessorConnection, 0, len(dataModel.Processors))
+	for processor := range dataModel.Processors {
+		processors = append(processors, processor)
+	}
+	if safe {
+		dataModel.Unlock()
+	}
+
+	processorStatistics := make([]ProcessorStatistics, len(processors))
+	for i, processor := range processors {
+		processorStatistics[i] = processor.GetProcessorStatistics()
+	}
+	return processorStatistics
+}
+
+// Subscribe registers a queue receiving every accepted vehicle update. Overflow policy can be empty for the default one.
+// Returns the history received in the last replay duration, so it can be sent without missing any update in between.
+func (dataModel *DataModel) Subscribe(overflow string, replay time.Duration, safe bool) (*UpdateQueue, []HistoryEntry) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	if overflow == "" {
+		overflow = dataModel.UpdateQueueOverflow
+	}
+	queue := NewUpdateQueue(dataModel.UpdateQueueCapacity, overflow)
+	dataModel.UpdateQueues[queue] = true
+	if replay <= 0 {
+		return queue, nil
+	}
+	return queue, dataModel.GetHistory(time.Now().Add(-replay), false)
+}
+
+func (dataModel *DataModel) Unsubscribe(queue *UpdateQueue, safe bool) {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+	delete(dataModel.UpdateQueues, queue)
+}
+
+// GetHistory returns updates of all vehicles received at the given time or later, oldest first.
+func (dataModel *DataModel) GetHistory(since time.Time, safe bool) []HistoryEntry {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	var entries []HistoryEntry
+	for _, history := range dataModel.History {
+		entries = append(entries, history.Since(since)...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ReceivedAt.Before(entries[j].ReceivedAt)
+	})
+	return entries
+}
+
 func (dataModel *DataModel) GetVehicleById(id string) api.UpdateVehicleVehicle {
 	// Look up the vehicle by ID directly
 	vehicle, ok := dataModel.Vehicles[id]
@@ -178,19 +583,19 @@ func (dataModel *DataModel) GetVehicleById(id string) api.UpdateVehicleVehicle {
 	return vehicle.UpdateVehicleVehicle
 }
 
-func (dataModel *DataModel) GetVehicleDecisionById(id string) api.UpdateVehicleDecision {
+// GetVehicleDecisionById returns a copy of the decision of the vehicle, ok is false if the vehicle has no decision.
+// The vehicle may have been removed since its decision was updated, e.g. by a hand-off or a reload.
+func (dataModel *DataModel) GetVehicleDecisionById(id string) (api.UpdateVehicleDecision, bool) {
 	// Look up the vehicle by ID directly
 	vehicle, ok := dataModel.VehicleDecisions[id]
 	if !ok {
-		log.Fatalf("Vehicle decision with id %v not found", id)
-		return api.UpdateVehicleDecision{}
-
+		return api.UpdateVehicleDecision{}, false
 	}
 	// Vehicle found, return the corresponding UpdateVehiclesVehicle
 	return api.UpdateVehicleDecision{
 		Vin:     vehicle.Vin,
 		Message: vehicle.Message,
-	}
+	}, true
 }
 
 func (dataModel *DataModel) GetVehicleConnection(vehicleId int, safe bool) *VehicleConnection {
@@ -207,11 +612,12 @@ func (dataModel *DataModel) GetVehicleConnection(vehicleId int, safe bool) *Vehi
 
 type Vehicle struct {
 	api.UpdateVehicleVehicle
-	Timestamp string
+	Timestamp  string
+	Stale      bool   `json:"stale,omitempty"`       // Restored from a snapshot and not updated by the vehicle since
+	RestoredAt string `json:"restored_at,omitempty"` // Time of the restore of a stale vehicle
 }
 
 type Notification struct {
 	Id       int
 	Datagram *api.UpdateNotificationsNotification
 }
-
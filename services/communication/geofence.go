@@ -0,0 +1,207 @@
+package communication
+
+import (
+	"car-integration/models"
+	"sort"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+const (
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+)
+
+// Reasons of exits without crossing the zone boundary
+const (
+	GeofenceReasonDisconnected = "disconnected" // The connection of the vehicle timed out
+	GeofenceReasonHandedOff    = "handed-off"   // The vehicle was handed off to a neighbouring instance
+	GeofenceReasonRemoved      = "removed"      // The vehicle was removed by the admin API, a reload or as stale
+	GeofenceReasonZoneRemoved  = "zone-removed" // The zone was removed from the area by a reload
+)
+
+// GeofenceEventDatagram notifies processors that a vehicle entered or left a zone of the area.
+type GeofenceEventDatagram struct {
+	api.BaseDatagram
+	GeofenceEvent
+}
+
+type GeofenceEvent struct {
+	Sequence       int              `json:"sequence"`
+	Vin            string           `json:"vin"`
+	Zone           string           `json:"zone"`
+	ZoneKind       string           `json:"zone_kind"`
+	Direction      string           `json:"direction"` // GeofenceEnter or GeofenceExit
+	EventTimestamp string           `json:"event_timestamp"`
+	Position       api.PositionJSON `json:"position"`
+	Reason         string           `json:"reason,omitempty"` // Why the vehicle left without crossing the boundary, empty for a crossing
+}
+
+type zoneState struct {
+	Inside  bool
+	Pending int // Number of consecutive positions contradicting Inside
+}
+
+// GeofenceTracker detects vehicles entering and leaving zones. A vehicle changes its state only after
+// Confirmations consecutive positions on the other side of the boundary, so GPS noise does not produce events.
+// Not thread safe, guarded by the DataModel lock.
+type GeofenceTracker struct {
+	Confirmations int
+	States        map[string]map[string]*zoneState // Mapping VIN to zone name to state
+	Events        []GeofenceEvent                  // Recent events, at most MaxEvents
+	MaxEvents     int
+	NextSequence  int
+}
+
+func NewGeofenceTracker(confirmations int, maxEvents int) *GeofenceTracker {
+	return &GeofenceTracker{
+		Confirmations: max(confirmations, 1),
+		States:        make(map[string]map[string]*zoneState),
+		MaxEvents:     maxEvents,
+		NextSequence:  1,
+	}
+}
+
+// Update processes a new position of the vehicle and returns the number of new events.
+func (tracker *GeofenceTracker) Update(vin string, zones []models.Zone, position api.PositionJSON, timestamp string) int {
+	states, ok := tracker.States[vin]
+	if !ok {
+		states = make(map[string]*zoneState)
+		tracker.States[vin] = states
+	}
+
+	count := 0
+	for i := range zones {
+		zone := &zones[i]
+		inside := zone.Contains(&position)
+
+		state, ok := states[zone.Name]
+		if !ok {
+			// The first position of the vehicle is taken as is, without an event for zones it is outside of
+			state = &zoneState{}
+			states[zone.Name] = state
+			if !inside {
+				continue
+			}
+			state.Pending = tracker.Confirmations - 1
+		}
+
+		if inside == state.Inside {
+			state.Pending = 0
+			continue
+		}
+		state.Pending++
+		if state.Pending < tracker.Confirmations {
+			continue
+		}
+
+		state.Inside = inside
+		state.Pending = 0
+		direction := GeofenceExit
+		if inside {
+			direction = GeofenceEnter
+		}
+		tracker.push(GeofenceEvent{
+			Vin:            vin,
+			Zone:           zone.Name,
+			ZoneKind:       zone.Kind,
+			Direction:      direction,
+			EventTimestamp: timestamp,
+			Position:       position,
+		})
+		count++
+	}
+	return count
+}
+
+// Forget drops the state of the vehicle, e.g. when it disconnects. The vehicle exits every zone it is inside of
+// with the reason at its last position. Returns the number of new events.
+func (tracker *GeofenceTracker) Forget(vin string, zones []models.Zone, reason string, position api.PositionJSON, timestamp string) int {
+	states := tracker.States[vin]
+	delete(tracker.States, vin)
+
+	var names []string
+	for name, state := range states {
+		if state.Inside {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// Kind of a zone removed from the area in the meantime is not known anymore
+		var kind string
+		for i := range zones {
+			if zones[i].Name == name {
+				kind = zones[i].Kind
+			}
+		}
+		tracker.push(GeofenceEvent{
+			Vin:            vin,
+			Zone:           name,
+			ZoneKind:       kind,
+			Direction:      GeofenceExit,
+			EventTimestamp: timestamp,
+			Position:       position,
+			Reason:         reason,
+		})
+	}
+	return len(names)
+}
+
+// ForgetZones drops the state of the zones in all vehicles, e.g. when they were removed from the area. Vehicles inside
+// of them exit them with the reason at their positions, vehicles without a position exit at zero. Returns the number of new events.
+func (tracker *GeofenceTracker) ForgetZones(zones []models.Zone, reason string, positions map[string]api.PositionJSON, timestamp string) int {
+	vins := make([]string, 0, len(tracker.States))
+	for vin := range tracker.States {
+		vins = append(vins, vin)
+	}
+	sort.Strings(vins)
+
+	count := 0
+	for _, vin := range vins {
+		states := tracker.States[vin]
+		for i := range zones {
+			zone := &zones[i]
+			state, ok := states[zone.Name]
+			if !ok {
+				continue
+			}
+			delete(states, zone.Name)
+			if !state.Inside {
+				continue
+			}
+			tracker.push(GeofenceEvent{
+				Vin:            vin,
+				Zone:           zone.Name,
+				ZoneKind:       zone.Kind,
+				Direction:      GeofenceExit,
+				EventTimestamp: timestamp,
+				Position:       positions[vin],
+				Reason:         reason,
+			})
+			count++
+		}
+	}
+	return count
+}
+
+// EventsAfter returns the events with sequence greater than the given one.
+func (tracker *GeofenceTracker) EventsAfter(sequence int) []GeofenceEvent {
+	first := len(tracker.Events)
+	for first > 0 && tracker.Events[first-1].Sequence > sequence {
+		first--
+	}
+	events := make([]GeofenceEvent, len(tracker.Events)-first)
+	copy(events, tracker.Events[first:])
+	return events
+}
+
+func (tracker *GeofenceTracker) push(event GeofenceEvent) {
+	event.Sequence = tracker.NextSequence
+	tracker.NextSequence++
+	tracker.Events = append(tracker.Events, event)
+	if len(tracker.Events) > tracker.MaxEvents {
+		tracker.Events = tracker.Events[len(tracker.Events)-tracker.MaxEvents:]
+	}
+}
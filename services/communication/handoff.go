@@ -0,0 +1,198 @@
+package communication
+
+import (
+	"car-integration/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+	"github.com/getsentry/sentry-go"
+)
+
+// HandoffVehicleDatagram transfers the last known state and decision of a vehicle to the Integration Module
+// managing the area the vehicle has entered.
+type HandoffVehicleDatagram struct {
+	api.BaseDatagram
+	Vehicle          api.UpdateVehicleVehicle   `json:"vehicle"`
+	VehicleTimestamp string                     `json:"vehicle_timestamp"`
+	VehicleDecision  *api.UpdateVehicleDecision `json:"updateVehicleDecision,omitempty"`
+	// Time the decision was received by the sending instance, so the newer of the decisions known to both instances wins
+	DecisionTimestamp string `json:"decision_timestamp,omitempty"`
+}
+
+// Handoff sends vehicles leaving the managed area to the instances managing the neighbouring areas.
+type Handoff struct {
+	sync.Mutex
+	Neighbours  []models.Neighbour
+	UDPConn     *net.UDPConn
+	Connections map[string]*Connection // Mapping neighbour name to the connection to its handoff endpoint
+	Delivery    *ReliableDeliveryOptions
+}
+
+func NewHandoff(neighbours []models.Neighbour, delivery *ReliableDeliveryOptions) (*Handoff, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	handoff := &Handoff{
+		Neighbours:  neighbours,
+		UDPConn:     conn,
+		Connections: make(map[string]*Connection),
+		Delivery:    delivery,
+	}
+	go handoff.listen()
+	return handoff, nil
+}
+
+// FindNeighbour returns the neighbour whose area contains the position.
+func (handoff *Handoff) FindNeighbour(position *api.PositionJSON, safe bool) *models.Neighbour {
+	if safe {
+		handoff.Lock()
+		defer handoff.Unlock()
+	}
+	return models.FindNeighbour(handoff.Neighbours, position)
+}
+
+// SetNeighbours replaces the neighbours, connections to the removed ones finish their outstanding transfers.
+func (handoff *Handoff) SetNeighbours(neighbours []models.Neighbour, safe bool) {
+	if safe {
+		handoff.Lock()
+		defer handoff.Unlock()
+	}
+	handoff.Neighbours = neighbours
+}
+
+func (handoff *Handoff) GetNeighbours(safe bool) []models.Neighbour {
+	if safe {
+		handoff.Lock()
+		defer handoff.Unlock()
+	}
+	return handoff.Neighbours
+}
+
+// TransferVehicle sends the vehicle state and decision to the neighbour, retransmitting until it is acknowledged.
+func (handoff *Handoff) TransferVehicle(neighbour *models.Neighbour, vehicle *Vehicle, decision *api.UpdateVehicleDecision, decisionReceivedAt time.Time) {
+	connection, err := handoff.GetConnection(neighbour, true)
+	if err != nil {
+		sentry.CaptureException(err)
+		fmt.Printf("Failed to hand off vehicle %v to %v: %v\n", vehicle.Vin, neighbour.Name, err)
+		return
+	}
+
+	datagram := &HandoffVehicleDatagram{
+		BaseDatagram:     api.BaseDatagram{Type: "handoff_vehicle"},
+		Vehicle:          vehicle.UpdateVehicleVehicle,
+		VehicleTimestamp: vehicle.Timestamp,
+		VehicleDecision:  decision,
+	}
+	if decision != nil && !decisionReceivedAt.IsZero() {
+		datagram.DecisionTimestamp = decisionReceivedAt.UTC().Format(api.TimestampFormat)
+	}
+	connection.WriteReliableDatagram(datagram, nil, vehicle.Vin, true)
+	fmt.Printf("Handing off vehicle %v to %v\n", vehicle.Vin, neighbour.Name)
+}
+
+func (handoff *Handoff) GetConnection(neighbour *models.Neighbour, safe bool) (*Connection, error) {
+	if safe {
+		handoff.Lock()
+		defer handoff.Unlock()
+	}
+
+	connection, ok := handoff.Connections[neighbour.Name]
+	if ok {
+		return connection, nil
+	}
+
+	address, err := net.ResolveUDPAddr("udp", neighbour.HandoffEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	connection = &Connection{
+		UDPConn:           handoff.UDPConn,
+		ClientAddress:     address,
+		NextSendIndex:     1,
+		LastReceivedIndex: -1,
+	}
+	if handoff.Delivery != nil {
+		connection.Delivery = NewReliableDelivery(handoff.UDPConn, *handoff.Delivery)
+	}
+	handoff.Connections[neighbour.Name] = connection
+	return connection, nil
+}
+
+// Close waits until the neighbours acknowledge the transferred vehicles or the context is done,
+// then gives up the remaining transfers and closes the socket.
+func (handoff *Handoff) Close(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && handoff.getPendingCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	handoff.Lock()
+	for _, connection := range handoff.Connections {
+		connection.OnDead(true)
+	}
+	handoff.Unlock()
+
+	closeErr := handoff.UDPConn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (handoff *Handoff) getPendingCount() int {
+	handoff.Lock()
+	defer handoff.Unlock()
+
+	pending := 0
+	for _, connection := range handoff.Connections {
+		if connection.Delivery != nil {
+			pending += connection.Delivery.GetPendingCount(true)
+		}
+	}
+	return pending
+}
+
+// listen receives acknowledgements of the transferred vehicles.
+func (handoff *Handoff) listen() {
+	readBuffer := make([]byte, 65536)
+	for {
+		readBufferLength, clientAddress, err := handoff.UDPConn.ReadFromUDP(readBuffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			sentry.CaptureException(err)
+			fmt.Printf("Error reading handoff message %v\n", err)
+			continue
+		}
+
+		var datagram api.BaseDatagram
+		err = json.Unmarshal(readBuffer[:readBufferLength], &datagram)
+		if err != nil || datagram.Type != "acknowledge" {
+			continue
+		}
+
+		handoff.Lock()
+		for _, connection := range handoff.Connections {
+			if connection.ClientAddress.String() == clientAddress.String() {
+				connection.Acknowledge(readBuffer[:readBufferLength])
+			}
+		}
+		handoff.Unlock()
+	}
+}
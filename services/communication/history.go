@@ -0,0 +1,67 @@
+package communication
+
+import (
+	"sort"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+type HistoryEntry struct {
+	ReceivedAt time.Time
+	Timestamp  string // Timestamp of the datagram sent by the vehicle
+	Vehicle    api.UpdateVehicleVehicle
+}
+
+// VehicleHistory is a ring buffer of the latest updates of one vehicle, ordered by the time they were received.
+// Entries are dropped when there are more than MaxEntries or they are older than MaxAge (0 for no limit).
+// Not thread safe, guarded by the DataModel lock.
+type VehicleHistory struct {
+	Entries []HistoryEntry
+	Start   int
+	Count   int
+	MaxAge  time.Duration
+}
+
+func NewVehicleHistory(maxEntries int, maxAge time.Duration) *VehicleHistory {
+	return &VehicleHistory{
+		Entries: make([]HistoryEntry, max(maxEntries, 1)),
+		MaxAge:  maxAge,
+	}
+}
+
+func (history *VehicleHistory) Push(entry HistoryEntry) {
+	if history.Count == len(history.Entries) {
+		history.Start = (history.Start + 1) % len(history.Entries)
+		history.Count--
+	}
+	history.Entries[(history.Start+history.Count)%len(history.Entries)] = entry
+	history.Count++
+
+	if history.MaxAge > 0 {
+		history.dropBefore(entry.ReceivedAt.Add(-history.MaxAge))
+	}
+}
+
+// Since returns the entries received at the given time or later, oldest first.
+func (history *VehicleHistory) Since(since time.Time) []HistoryEntry {
+	first := sort.Search(history.Count, func(i int) bool {
+		return !history.at(i).ReceivedAt.Before(since)
+	})
+	entries := make([]HistoryEntry, 0, history.Count-first)
+	for i := first; i < history.Count; i++ {
+		entries = append(entries, *history.at(i))
+	}
+	return entries
+}
+
+func (history *VehicleHistory) at(i int) *HistoryEntry {
+	return &history.Entries[(history.Start+i)%len(history.Entries)]
+}
+
+func (history *VehicleHistory) dropBefore(since time.Time) {
+	for history.Count > 0 && history.at(0).ReceivedAt.Before(since) {
+		history.Start = (history.Start + 1) % len(history.Entries)
+		history.Count--
+	}
+}
@@ -0,0 +1,122 @@
+package communication
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// PingReply is a ping sent by the Integration Module and acknowledged by the client.
+type PingReply struct {
+	Index           int
+	SentAt          time.Time
+	ReceivedAt      time.Time
+	RemoteTimestamp string // Timestamp of the acknowledgement, in the clock of the client
+}
+
+// Pinger periodically sends ping datagrams to the client of the connection and matches its acknowledgements.
+// Pings not acknowledged within LostAfter are counted as lost.
+type Pinger struct {
+	sync.Mutex
+	Connection  *Connection
+	Interval    time.Duration
+	LostAfter   time.Duration
+	Outstanding map[int]time.Time // Mapping index of the ping to the time it was sent
+	Sent        int64
+	Received    int64
+	Lost        int64
+	LastReply   time.Time
+
+	address func() *net.UDPAddr // Address the pings are sent to, nil result for the client address
+	onReply func(reply PingReply)
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewPinger(connection *Connection, interval time.Duration, address func() *net.UDPAddr, onReply func(reply PingReply)) *Pinger {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pinger{
+		Connection:  connection,
+		Interval:    interval,
+		LostAfter:   max(5*interval, time.Second),
+		Outstanding: make(map[int]time.Time),
+		address:     address,
+		onReply:     onReply,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Start sends pings until Stop is called.
+func (pinger *Pinger) Start() {
+	ticker := time.NewTicker(pinger.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pinger.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var address *net.UDPAddr
+		if pinger.address != nil {
+			address = pinger.address()
+		}
+		datagram := &api.PingDatagram{BaseDatagram: api.BaseDatagram{Type: "ping"}}
+		// The ping is outstanding before it is sent, the acknowledgement may be processed before writeDatagram returns
+		pinger.Connection.writeDatagram(datagram, address, "", false, nil, pinger.register, true)
+	}
+}
+
+// register records the ping as outstanding and counts the pings not acknowledged in time as lost.
+func (pinger *Pinger) register(index int) {
+	pinger.Lock()
+	defer pinger.Unlock()
+
+	sentAt := time.Now()
+	pinger.Outstanding[index] = sentAt
+	pinger.Sent++
+	for outstandingIndex, outstandingSentAt := range pinger.Outstanding {
+		if sentAt.Sub(outstandingSentAt) > pinger.LostAfter {
+			delete(pinger.Outstanding, outstandingIndex)
+			pinger.Lost++
+		}
+	}
+}
+
+func (pinger *Pinger) Stop() {
+	pinger.cancel()
+}
+
+// Acknowledge matches the acknowledgement to an outstanding ping. Returns false if it does not acknowledge a ping.
+func (pinger *Pinger) Acknowledge(datagram *api.AcknowledgeDatagram, receivedAt time.Time) bool {
+	pinger.Lock()
+	sentAt, ok := pinger.Outstanding[datagram.AcknowledgingIndex]
+	if ok {
+		delete(pinger.Outstanding, datagram.AcknowledgingIndex)
+		pinger.Received++
+		pinger.LastReply = receivedAt
+	}
+	pinger.Unlock()
+
+	if ok && pinger.onReply != nil {
+		pinger.onReply(PingReply{
+			Index:           datagram.AcknowledgingIndex,
+			SentAt:          sentAt,
+			ReceivedAt:      receivedAt,
+			RemoteTimestamp: datagram.Timestamp,
+		})
+	}
+	return ok
+}
+
+// GetCounters returns the number of pings sent, acknowledged and lost.
+func (pinger *Pinger) GetCounters() (sent int64, received int64, lost int64) {
+	pinger.Lock()
+	defer pinger.Unlock()
+	return pinger.Sent, pinger.Received, pinger.Lost
+}
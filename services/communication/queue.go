@@ -0,0 +1,90 @@
+package communication
+
+import (
+	"sync"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Overflow policies of the UpdateQueue
+const (
+	OverflowDropOldest = "drop-oldest" // The oldest queued update is dropped to make room
+	OverflowCoalesce   = "coalesce"    // The queued update of the same vehicle is replaced, the oldest is dropped if there is none
+)
+
+// UpdateQueue is a bounded queue of vehicle updates waiting to be sent to one subscriber.
+// Push never blocks, so a slow subscriber cannot stall the DataModel. Dropped and coalesced updates are counted.
+type UpdateQueue struct {
+	sync.Mutex
+	Items     []api.UpdateVehicleVehicle
+	Capacity  int
+	Overflow  string
+	Dropped   int64
+	Coalesced int64
+	notify    chan struct{}
+}
+
+func NewUpdateQueue(capacity int, overflow string) *UpdateQueue {
+	if overflow != OverflowDropOldest {
+		overflow = OverflowCoalesce
+	}
+	return &UpdateQueue{
+		Items:    make([]api.UpdateVehicleVehicle, 0, max(capacity, 1)),
+		Capacity: max(capacity, 1),
+		Overflow: overflow,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (queue *UpdateQueue) Push(vehicle api.UpdateVehicleVehicle) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	if len(queue.Items) >= queue.Capacity {
+		coalesced := false
+		if queue.Overflow == OverflowCoalesce {
+			for i := range queue.Items {
+				if queue.Items[i].Vin == vehicle.Vin {
+					queue.Items[i] = vehicle
+					queue.Coalesced++
+					coalesced = true
+					break
+				}
+			}
+		}
+		if coalesced {
+			return
+		}
+		queue.Items = append(queue.Items[:0], queue.Items[1:]...)
+		queue.Dropped++
+	}
+	queue.Items = append(queue.Items, vehicle)
+
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Drain removes and returns all queued updates, oldest first.
+func (queue *UpdateQueue) Drain() []api.UpdateVehicleVehicle {
+	queue.Lock()
+	defer queue.Unlock()
+
+	items := make([]api.UpdateVehicleVehicle, len(queue.Items))
+	copy(items, queue.Items)
+	queue.Items = queue.Items[:0]
+	return items
+}
+
+// Notify returns channel receiving a value when an update was pushed since the last receive.
+func (queue *UpdateQueue) Notify() <-chan struct{} {
+	return queue.notify
+}
+
+// GetLosses returns the number of dropped and coalesced updates.
+func (queue *UpdateQueue) GetLosses() (dropped int64, coalesced int64) {
+	queue.Lock()
+	defer queue.Unlock()
+	return queue.Dropped, queue.Coalesced
+}
@@ -0,0 +1,201 @@
+package communication
+
+import (
+	"car-integration/services/metrics"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ReliableDeliveryOptions configures retransmission of datagrams which have to be acknowledged by the client.
+type ReliableDeliveryOptions struct {
+	RetransmitTimeout    time.Duration // Delay before the first retransmission
+	MaxRetransmitTimeout time.Duration // Upper bound of the exponential backoff between retransmissions
+	Deadline             time.Duration // Time after which an unacknowledged datagram is given up
+}
+
+func DefaultReliableDeliveryOptions() *ReliableDeliveryOptions {
+	return &ReliableDeliveryOptions{
+		RetransmitTimeout:    100 * time.Millisecond,
+		MaxRetransmitTimeout: 800 * time.Millisecond,
+		Deadline:             3 * time.Second,
+	}
+}
+
+// DeliveryStats are counters describing the reliable delivery of one connection.
+type DeliveryStats struct {
+	Sent            int64
+	Acknowledged    int64
+	Retransmissions int64
+	Expired         int64
+}
+
+// Outcomes of a reliable delivery reported to the DeliveryCallback
+const (
+	DeliveryAcknowledged = "acknowledged"
+	DeliveryExpired      = "expired"
+	DeliverySuperseded   = "superseded" // A newer datagram of the same type and topic was sent before the acknowledgement
+	DeliveryStopped      = "stopped"    // The connection ended before the acknowledgement
+)
+
+// DeliveryCallback is called with the index of the datagram, the outcome and the number of sent copies once the delivery ends.
+// It is called with the ReliableDelivery locked.
+type DeliveryCallback func(index int, outcome string, attempts int)
+
+type pendingDatagram struct {
+	Index    int
+	Type     string
+	Topic    string // Subject of the datagram, e.g. the VIN of a decision
+	Data     []byte
+	Address  *net.UDPAddr
+	SentAt   time.Time
+	Timeout  time.Duration
+	Attempts int
+	OnDone   DeliveryCallback // Nil if the outcome is not needed
+	timer    *time.Timer
+}
+
+func (pending *pendingDatagram) done(outcome string) {
+	pending.timer.Stop()
+	if pending.OnDone != nil {
+		pending.OnDone(pending.Index, outcome, pending.Attempts)
+	}
+}
+
+// ReliableDelivery keeps track of outstanding datagrams of a connection and retransmits them
+// with exponential backoff until they are acknowledged or their deadline passes.
+// A newer datagram of the same type and topic supersedes the outstanding one, so stale state is never retransmitted.
+// Datagrams of other topics, e.g. decisions of other vehicles routed through the same connection, stay outstanding.
+type ReliableDelivery struct {
+	sync.Mutex
+	UDPConn *net.UDPConn
+	Options ReliableDeliveryOptions
+	Pending map[int]*pendingDatagram
+	Stats   DeliveryStats
+}
+
+func NewReliableDelivery(conn *net.UDPConn, options ReliableDeliveryOptions) *ReliableDelivery {
+	return &ReliableDelivery{
+		UDPConn: conn,
+		Options: options,
+		Pending: make(map[int]*pendingDatagram),
+	}
+}
+
+// Track registers a datagram that was just sent and schedules its retransmission, onDone may be nil.
+func (delivery *ReliableDelivery) Track(index int, datagramType string, topic string, data []byte, address *net.UDPAddr, onDone DeliveryCallback, safe bool) {
+	if safe {
+		delivery.Lock()
+		defer delivery.Unlock()
+	}
+
+	for pendingIndex, pending := range delivery.Pending {
+		if pending.Type == datagramType && pending.Topic == topic {
+			pending.done(DeliverySuperseded)
+			delete(delivery.Pending, pendingIndex)
+		}
+	}
+
+	pending := &pendingDatagram{
+		Index:    index,
+		Type:     datagramType,
+		Topic:    topic,
+		Data:     data,
+		Address:  address,
+		SentAt:   time.Now(),
+		Timeout:  delivery.Options.RetransmitTimeout,
+		Attempts: 1,
+		OnDone:   onDone,
+	}
+	pending.timer = time.AfterFunc(pending.Timeout, func() { delivery.retransmit(index) })
+	delivery.Pending[index] = pending
+	delivery.Stats.Sent++
+}
+
+// Acknowledge removes the datagram with the given index from the outstanding datagrams.
+// Returns false if no such datagram was outstanding.
+func (delivery *ReliableDelivery) Acknowledge(index int, safe bool) bool {
+	if safe {
+		delivery.Lock()
+		defer delivery.Unlock()
+	}
+
+	pending, ok := delivery.Pending[index]
+	if !ok {
+		return false
+	}
+	pending.done(DeliveryAcknowledged)
+	delete(delivery.Pending, index)
+	delivery.Stats.Acknowledged++
+	return true
+}
+
+// Stop cancels retransmission of all outstanding datagrams.
+func (delivery *ReliableDelivery) Stop(safe bool) {
+	if safe {
+		delivery.Lock()
+		defer delivery.Unlock()
+	}
+
+	for index, pending := range delivery.Pending {
+		pending.done(DeliveryStopped)
+		delete(delivery.Pending, index)
+	}
+}
+
+// GetPendingCount returns the number of datagrams waiting for acknowledgement.
+func (delivery *ReliableDelivery) GetPendingCount(safe bool) int {
+	if safe {
+		delivery.Lock()
+		defer delivery.Unlock()
+	}
+	return len(delivery.Pending)
+}
+
+func (delivery *ReliableDelivery) GetStats(safe bool) DeliveryStats {
+	if safe {
+		delivery.Lock()
+		defer delivery.Unlock()
+	}
+	return delivery.Stats
+}
+
+func (delivery *ReliableDelivery) retransmit(index int) {
+	delivery.Lock()
+	defer delivery.Unlock()
+
+	pending, ok := delivery.Pending[index]
+	if !ok {
+		return
+	}
+
+	if time.Since(pending.SentAt) >= delivery.Options.Deadline {
+		pending.done(DeliveryExpired)
+		delete(delivery.Pending, index)
+		delivery.Stats.Expired++
+		metrics.DeliveryExpired()
+		message := fmt.Sprintf("Datagram %v (%v) to %v was not acknowledged after %v attempts",
+			pending.Index, pending.Type, pending.Address, pending.Attempts)
+		sentry.CaptureMessage(message)
+		fmt.Println(message)
+		return
+	}
+
+	_, err := delivery.UDPConn.WriteToUDP(pending.Data, pending.Address)
+	if err != nil {
+		sentry.CaptureException(err)
+		fmt.Printf("Error retransmitting datagram with error %v\n", err)
+	}
+	if address, ok := delivery.UDPConn.LocalAddr().(*net.UDPAddr); ok {
+		metrics.DatagramSent(address.Port, pending.Type)
+	}
+	pending.Attempts++
+	delivery.Stats.Retransmissions++
+
+	remaining := delivery.Options.Deadline - time.Since(pending.SentAt)
+	pending.Timeout = min(pending.Timeout*2, delivery.Options.MaxRetransmitTimeout, remaining)
+	pending.timer = time.AfterFunc(pending.Timeout, func() { delivery.retransmit(index) })
+}
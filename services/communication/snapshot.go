@@ -0,0 +1,128 @@
+package communication
+
+import (
+	statistics "car-integration/services/statistics"
+	"fmt"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Snapshot is the state of the DataModel persisted across restarts of the module.
+// Connections and subscriptions are not part of it, vehicles and processors connect again.
+type Snapshot struct {
+	TakenAt            string                               `json:"taken_at"`
+	Vehicles           map[string]Vehicle                   `json:"vehicles"`
+	Decisions          map[string]api.UpdateVehicleDecision `json:"decisions"`
+	Notifications      map[int]map[string]*Notification     `json:"notifications"`
+	NextVehicleId      int                                  `json:"next_vehicle_id"`
+	NextNotificationId int                                  `json:"next_notification_id"`
+	NetworkStats       map[string]*statistics.NetworkStats  `json:"network_stats"`
+}
+
+// StaleVehicle is a vehicle restored from a snapshot, which did not send an update since.
+type StaleVehicle struct {
+	Vin        string `json:"vin"`
+	Timestamp  string `json:"timestamp"` // Timestamp of the last update of the vehicle before the restart
+	RestoredAt string `json:"restored_at"`
+}
+
+// VehiclesDatagram extends the vehicles update of the API with the stale vehicles,
+// whose state may be outdated. Fields of the API stay in place, so existing consumers keep working.
+type VehiclesDatagram struct {
+	api.UpdateVehiclesDatagram
+	Stale []StaleVehicle `json:"stale,omitempty"`
+}
+
+// TakeSnapshot copies the state of the DataModel. The network statistics are read from the StatsStore
+// after the DataModel is unlocked, so a slow store does not block the vehicle updates.
+func (dataModel *DataModel) TakeSnapshot() *Snapshot {
+	dataModel.Lock()
+	snapshot := &Snapshot{
+		TakenAt:            time.Now().UTC().Format(api.TimestampFormat),
+		Vehicles:           make(map[string]Vehicle, len(dataModel.Vehicles)),
+		Decisions:          make(map[string]api.UpdateVehicleDecision, len(dataModel.VehicleDecisions)),
+		Notifications:      make(map[int]map[string]*Notification, len(dataModel.Notifications)),
+		NextVehicleId:      dataModel.NextVehicleId,
+		NextNotificationId: dataModel.NextNotificationId,
+	}
+	vins := make([]string, 0, len(dataModel.Vehicles))
+	for vin, vehicle := range dataModel.Vehicles {
+		snapshot.Vehicles[vin] = *vehicle
+		vins = append(vins, vin)
+	}
+	for vin, decision := range dataModel.VehicleDecisions {
+		snapshot.Decisions[vin] = *decision
+	}
+	for id, notifications := range dataModel.Notifications {
+		snapshot.Notifications[id] = make(map[string]*Notification, len(notifications))
+		for vin, notification := range notifications {
+			copied := *notification
+			snapshot.Notifications[id][vin] = &copied
+		}
+	}
+	dataModel.Unlock()
+
+	stats, err := dataModel.StatsStore.GetMany(vins)
+	if err != nil {
+		fmt.Printf("Snapshot without network statistics: %v\n", err)
+	}
+	snapshot.NetworkStats = stats
+	return snapshot
+}
+
+// Restore fills the DataModel with the snapshot. Restored vehicles are marked stale until they send an update,
+// vehicles already known are kept as they are newer. Returns the VINs of the restored vehicles.
+func (dataModel *DataModel) Restore(snapshot *Snapshot, safe bool) []string {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	restoredAt := time.Now().UTC().Format(api.TimestampFormat)
+	var restored []string
+	for vin, vehicle := range snapshot.Vehicles {
+		if _, ok := dataModel.Vehicles[vin]; ok || !dataModel.IsVinAllowed(vin, false) {
+			continue
+		}
+		vehicle.Stale = true
+		vehicle.RestoredAt = restoredAt
+		dataModel.Vehicles[vin] = &vehicle
+		restored = append(restored, vin)
+
+		if decision, ok := snapshot.Decisions[vin]; ok {
+			if _, ok := dataModel.VehicleDecisions[vin]; !ok {
+				dataModel.VehicleDecisions[vin] = &decision
+			}
+		}
+		if stats, ok := snapshot.NetworkStats[vin]; ok {
+			_ = dataModel.StatsStore.Save(vin, stats)
+		}
+	}
+	for id, notifications := range snapshot.Notifications {
+		if _, ok := dataModel.Notifications[id]; !ok {
+			dataModel.Notifications[id] = notifications
+		}
+	}
+	dataModel.NextVehicleId = max(dataModel.NextVehicleId, snapshot.NextVehicleId)
+	dataModel.NextNotificationId = max(dataModel.NextNotificationId, snapshot.NextNotificationId)
+	return restored
+}
+
+// RemoveStaleVehicles removes the restored vehicles which did not send any update since, with their decisions.
+// Returns their VINs.
+func (dataModel *DataModel) RemoveStaleVehicles(safe bool) []string {
+	if safe {
+		dataModel.Lock()
+		defer dataModel.Unlock()
+	}
+
+	var removed []string
+	for vin, vehicle := range dataModel.Vehicles {
+		if vehicle.Stale {
+			dataModel.RemoveVehicle(vin, GeofenceReasonRemoved, false)
+			removed = append(removed, vin)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,104 @@
+package communication
+
+import (
+	"car-integration/services/statistics"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// NetworkStatisticsDatagram extends the network statistics of the API with the VIN and packet sequence statistics.
+// Fields of the API stay in place, so existing consumers keep working.
+type NetworkStatisticsDatagram struct {
+	api.BaseDatagram
+	NetworkStatistics []NetworkStatistics `json:"networkStatistics"`
+}
+
+type NetworkStatistics struct {
+	api.NetworkStatistics
+	Vin              string             `json:"vin"`
+	PacketsLost      int64              `json:"packetsLost"`
+	LossRate         float64            `json:"lossRate"`
+	OutOfOrder       int64              `json:"outOfOrder"`
+	Duplicates       int64              `json:"duplicates"`
+	LongestGap       int                `json:"longestGap"`
+	SequenceResets   int64              `json:"sequenceResets"`
+	ClockOffset      int64              `json:"clockOffset"`      // Vehicle clock minus Integration Module clock, latencies are corrected by it
+	ClockOffsetError int64              `json:"clockOffsetError"` // Confidence of the offset, it is off by at most this much
+	RoundTripTime    int64              `json:"roundTripTime"`
+	ClockSamples     int64              `json:"clockSamples"` // Number of ping exchanges, the offset is unknown while 0
+	Windows          []WindowStatistics `json:"windows"`
+	WindowsAt        string             `json:"windowsAt"` // Time of the last packet the windows were computed at
+}
+
+// WindowStatistics describe the last WindowSeconds, durations are in nanoseconds like the other latency fields.
+type WindowStatistics struct {
+	WindowSeconds float64 `json:"windowSeconds"`
+	Packets       int64   `json:"packets"`
+	PacketRate    float64 `json:"packetRate"`
+	LatencyP50    int64   `json:"latencyP50"`
+	LatencyP95    int64   `json:"latencyP95"`
+	LatencyP99    int64   `json:"latencyP99"`
+	LatencyMax    int64   `json:"latencyMax"`
+	Jitter        int64   `json:"jitter"`
+}
+
+func NewNetworkStatistics(vin string, stats *statistics.NetworkStats) NetworkStatistics {
+	networkStatistics := NetworkStatistics{
+		NetworkStatistics: api.NetworkStatistics{
+			PacketsReceived: stats.PacketsReceived,
+			ReceiveErrors:   stats.ReceiveErrors,
+			AverageLatency:  int64(stats.AverageLatency),
+			Jitter:          int64(stats.Jitter),
+		},
+		Vin:              vin,
+		PacketsLost:      stats.PacketsLost,
+		LossRate:         stats.LossRate,
+		OutOfOrder:       stats.OutOfOrder,
+		Duplicates:       stats.Duplicates,
+		LongestGap:       stats.LongestGap,
+		SequenceResets:   stats.SequenceResets,
+		ClockOffset:      int64(stats.ClockOffset),
+		ClockOffsetError: int64(stats.ClockOffsetError),
+		RoundTripTime:    int64(stats.RoundTripTime),
+		ClockSamples:     stats.ClockSamples,
+		Windows:          make([]WindowStatistics, len(stats.Windows)),
+	}
+	if !stats.WindowsUpdatedAt.IsZero() {
+		networkStatistics.WindowsAt = stats.WindowsUpdatedAt.UTC().Format(api.TimestampFormat)
+	}
+	for i, window := range stats.Windows {
+		networkStatistics.Windows[i] = WindowStatistics{
+			WindowSeconds: window.Window.Seconds(),
+			Packets:       window.Packets,
+			PacketRate:    window.PacketRate,
+			LatencyP50:    int64(window.LatencyP50),
+			LatencyP95:    int64(window.LatencyP95),
+			LatencyP99:    int64(window.LatencyP99),
+			LatencyMax:    int64(window.LatencyMax),
+			Jitter:        int64(window.Jitter),
+		}
+	}
+	return networkStatistics
+}
+
+// ProcessorStatisticsDatagram contains statistics of the processors connected to the Integration Module.
+type ProcessorStatisticsDatagram struct {
+	api.BaseDatagram
+	ProcessorStatistics []ProcessorStatistics `json:"processorStatistics"`
+}
+
+// ProcessorStatistics describe pings sent to a processor, durations are in nanoseconds.
+type ProcessorStatistics struct {
+	Address            string  `json:"address"`
+	Port               int     `json:"port"` // Port of the Integration Module the processor is connected to
+	RoundTripTime      int64   `json:"roundTripTime"`
+	SmoothedRoundTrip  int64   `json:"smoothedRoundTrip"`
+	RoundTripVariation int64   `json:"roundTripVariation"`
+	MinRoundTrip       int64   `json:"minRoundTrip"`
+	MaxRoundTrip       int64   `json:"maxRoundTrip"`
+	PingsSent          int64   `json:"pingsSent"`
+	PingsReceived      int64   `json:"pingsReceived"`
+	PingsLost          int64   `json:"pingsLost"`
+	LossRate           float64 `json:"lossRate"`
+	LastSeen           string  `json:"lastSeen"`
+}
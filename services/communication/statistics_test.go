@@ -0,0 +1,73 @@
+package communication
+
+import (
+	"car-integration/models"
+	"car-integration/services/statistics"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+func TestNetworkStatisticsAreReadFromStore(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	processor, client := newTestProcessorConnection(t, dataModel)
+
+	module, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer module.Close()
+	vehicle := &VehicleConnection{
+		Connection: Connection{
+			UDPConn:           module,
+			ClientAddress:     module.LocalAddr().(*net.UDPAddr),
+			NextSendIndex:     1,
+			LastReceivedIndex: -1,
+			DataModel:         dataModel,
+		},
+		NetworkStats: statistics.NewNetworkStatistics(),
+	}
+
+	for index := 1; index <= 3; index++ {
+		data, _ := json.Marshal(&api.UpdateVehicleDatagram{
+			BaseDatagram: api.BaseDatagram{Type: "update_vehicle", Index: index, Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+			Vehicle:      api.UpdateVehicleVehicle{Vin: "VIN1"},
+		})
+		vehicle.ProcessDatagram(data, true)
+	}
+	defer func() {
+		if subscriptions := vehicle.GetSubscriptions(true); len(subscriptions) > 0 {
+			subscriptions[0].Stop(ErrUnsubscribed)
+			waitDone(t, subscriptions[0])
+		}
+	}()
+
+	subscription := NewSubscription(&processor.Connection, "periodic-updates", "network-statistics", 1)
+	go subscription.Start()
+	defer func() {
+		subscription.Stop(ErrUnsubscribed)
+		waitDone(t, subscription)
+	}()
+
+	buffer := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("network statistics were not sent: %v", err)
+	}
+	var datagram NetworkStatisticsDatagram
+	err = json.Unmarshal(buffer[:n], &datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datagram.NetworkStatistics) != 1 {
+		t.Fatalf("statistics of %v vehicles, expected 1: %s", len(datagram.NetworkStatistics), buffer[:n])
+	}
+	stats := datagram.NetworkStatistics[0]
+	if stats.Vin != "VIN1" || stats.PacketsReceived != 3 || stats.PacketsLost != 0 {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+}
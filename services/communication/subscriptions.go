@@ -1,127 +1,328 @@
 package communication
 
 import (
-	"car-integration/services/redis"
+	audit "car-integration/services/audit"
+	"car-integration/services/metrics"
+	"car-integration/services/routing"
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	api "github.com/TP-TEAM05/integration-api"
 )
 
+// SubscribeDatagram extends the subscribe datagram of the API with the optional replay of history.
+type SubscribeDatagram struct {
+	api.SubscribeDatagram
+	Replay   float32 `json:"replay"`   // Seconds of history sent before live updates, 0 to disable
+	Overflow string  `json:"overflow"` // Overflow policy of the live updates queue, empty for the default one
+}
+
+// Exit reasons of a subscription
+var (
+	ErrUnsubscribed   = errors.New("unsubscribed")
+	ErrConnectionDead = errors.New("connection is dead")
+	ErrReplaced       = errors.New("replaced by a new subscription")
+)
+
 type Subscription struct {
 	Connection *Connection
 	Content    string
 	Topic      string
 	Interval   float32
-	StopSignal chan bool
+	Replay     float32
+	Overflow   string
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+}
+
+func NewSubscription(connection *Connection, content string, topic string, interval float32) *Subscription {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &Subscription{
+		Connection: connection,
+		Content:    content,
+		Topic:      topic,
+		Interval:   interval,
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
 }
 
+// Start sends updates until the subscription is stopped or fails and returns the exit reason.
 func (subscription *Subscription) Start() error {
+	metrics.SubscriptionStarted(subscription.Content, subscription.Topic)
+	defer metrics.SubscriptionEnded(subscription.Content, subscription.Topic)
+
 	var err error
 	if subscription.Content == "periodic-updates" {
-		err = subscription.SendIntervalUpdates()
+		err = subscription.SendIntervalUpdates(subscription.ctx)
 	} else if subscription.Content == "live-updates" {
-		err = subscription.SendLiveUpdates()
+		err = subscription.SendLiveUpdates(subscription.ctx)
+	} else if subscription.Content == "geofence-events" {
+		err = subscription.SendGeofenceEvents(subscription.ctx)
 	} else if subscription.Content == "decision-update" {
-		err = subscription.SendDecisionUpdates()
+		err = subscription.SendDecisionUpdates(subscription.ctx)
 	} else {
 		err = errors.New("invalid content parameter: " + subscription.Content)
 	}
 
+	if subscription.ctx.Err() != nil {
+		err = context.Cause(subscription.ctx)
+	}
+	subscription.cancel(err)
+	subscription.err = err
+	close(subscription.done)
+
+	fmt.Printf("Subscription %v (%v) of %v ended: %v\n",
+		subscription.Content, subscription.Topic, subscription.Connection.GetClientAddress(true), err)
 	return err
 }
 
-func (subscription *Subscription) Stop() {
-	subscription.StopSignal <- true
+// Stop cancels the subscription with the reason, it does not wait for the subscription to end.
+func (subscription *Subscription) Stop(reason error) {
+	subscription.cancel(reason)
 }
 
-func (subscription *Subscription) SendLiveUpdates() error {
-	for {
-		subscription.Connection.DataModel.Lock()
+// Done returns channel closed once the subscription has ended.
+func (subscription *Subscription) Done() <-chan struct{} {
+	return subscription.done
+}
+
+// Err returns the exit reason of the subscription, nil while it is running.
+func (subscription *Subscription) Err() error {
+	select {
+	case <-subscription.done:
+		return subscription.err
+	default:
+		return nil
+	}
+}
+
+// waitCond waits on the DataModel condition, which has to be locked, until it is signalled or the context is cancelled.
+func waitCond(ctx context.Context, cond *sync.Cond) error {
+	stop := context.AfterFunc(ctx, func() {
+		cond.L.Lock()
+		defer cond.L.Unlock()
+		cond.Broadcast()
+	})
+	defer stop()
+
+	cond.Wait()
+	return ctx.Err()
+}
 
-		subscription.Connection.DataModel.updateCond.Wait()
+// SendLiveUpdates sends every vehicle update from the own queue of the subscription.
+// The DataModel is never locked while writing to the network, updates are lost only by the overflow policy of the queue.
+func (subscription *Subscription) SendLiveUpdates(ctx context.Context) error {
+	// History is replayed first, the queue already collects updates received in the meantime
+	replay := time.Duration(subscription.Replay * float32(time.Second))
+	queue, history := subscription.Connection.DataModel.Subscribe(subscription.Overflow, replay, true)
+	defer subscription.Connection.DataModel.Unsubscribe(queue, true)
 
-		var datagram = &api.UpdatePositionVehicleDatagram{
-			BaseDatagram: api.BaseDatagram{Type: "update_vehicle_position"},
-			Vehicle:      subscription.Connection.DataModel.GetVehicleById(subscription.Connection.DataModel.UpdatedVehicleVin),
+	for _, entry := range history {
+		subscription.sendVehicleUpdate(entry.Vehicle)
+	}
+
+	var reportedDropped, reportedCoalesced int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-queue.Notify():
+		}
+		for _, vehicle := range queue.Drain() {
+			subscription.sendVehicleUpdate(vehicle)
 		}
-		// DEBUG: Here are the data before sending
 
-		subscription.Connection.WriteDatagram(datagram, true)
-		subscription.Connection.DataModel.Unlock()
+		dropped, coalesced := queue.GetLosses()
+		if dropped != reportedDropped || coalesced != reportedCoalesced {
+			fmt.Printf("Live updates to %v are falling behind, %v updates dropped and %v coalesced in total\n",
+				subscription.Connection.GetClientAddress(true), dropped, coalesced)
+			reportedDropped, reportedCoalesced = dropped, coalesced
+		}
+	}
+}
 
+func (subscription *Subscription) sendVehicleUpdate(vehicle api.UpdateVehicleVehicle) {
+	var datagram = &api.UpdatePositionVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "update_vehicle_position"},
+		Vehicle:      vehicle,
 	}
+	// DEBUG: Here are the data before sending
+
+	subscription.Connection.WriteDatagram(datagram, true)
 }
 
-func (subscription *Subscription) SendDecisionUpdates() error {
+// SendDecisionUpdates forwards the current decision and every following one for the vehicle of the Topic.
+// The current decision is sent first, so a vehicle connecting after its decision arrived, e.g. by a hand-off, gets it too.
+// The condition is broadcast also for other vehicles and cancelled subscriptions, only an advanced generation of the Topic means a new decision.
+// Decisions received in between two wakeups are coalesced into the latest one, the DataModel is never locked while writing to the network.
+func (subscription *Subscription) SendDecisionUpdates(ctx context.Context) error {
+	dataModel := subscription.Connection.DataModel
+
+	// Generations start at 1, a vehicle without any decision yet waits for the first one
+	generation := 0
 	for {
-		subscription.Connection.DataModel.Lock()
+		dataModel.Lock()
+		for generation == dataModel.DecisionGenerations[subscription.Topic] {
+			err := waitCond(ctx, dataModel.updateCondDecision)
+			if err != nil {
+				dataModel.Unlock()
+				return err
+			}
+		}
+		generation = dataModel.DecisionGenerations[subscription.Topic]
 
-		subscription.Connection.DataModel.updateCondDecision.Wait()
+		decision, ok := dataModel.GetVehicleDecisionById(subscription.Topic)
+		decisionId := dataModel.VehicleDecisionIds[subscription.Topic]
+		receivedAt, received := dataModel.VehicleDecisionReceivedAt[subscription.Topic]
+		decisionLog := dataModel.DecisionLog
+		dataModel.Unlock()
 
-		if subscription.Topic == subscription.Connection.DataModel.UpdatedVehicleDecisionVin && subscription.Connection.DataModel.UpdatedVehicleVin != "C4RF117S7U0000001" {
-			var datagram = &api.UpdateVehicleDecisionDatagram{
-				BaseDatagram:    api.BaseDatagram{Type: "update_vehicle_position"},
-				VehicleDecision: subscription.Connection.DataModel.GetVehicleDecisionById(subscription.Connection.DataModel.UpdatedVehicleVin),
-			}
+		// The vehicle may have been removed before the subscription got the lock back
+		if !ok {
+			continue
+		}
 
-			// TODO: DEBUG: Here are the data before sending
+		var datagram = &api.UpdateVehicleDecisionDatagram{
+			BaseDatagram:    api.BaseDatagram{Type: "update_vehicle_position"},
+			VehicleDecision: decision,
+		}
 
-			// If the WriteDatagram has safe set to false, it will use hardcoded value located in the function `connection.go`
-			subscription.Connection.WriteDatagram(datagram, false)
-			// fmt.Printf("Sent decision update...... to car %v\n", subscription.Connection.DataModel.UpdatedVehicleVin)
+		// The return address of the vehicle is taken from the routing table, the connection address stays untouched.
+		// Decisions are retransmitted until the vehicle acknowledges them, if reliable delivery is enabled
+		address := routing.Resolve(subscription.Topic, subscription.Connection.GetClientAddress(true))
+		subscription.forwardDecision(datagram, address, decisionLog, decisionId)
+		if received {
+			metrics.DecisionForwarded(time.Since(receivedAt))
 		}
-		subscription.Connection.DataModel.Unlock()
+	}
+}
+
+// forwardDecision sends the decision to the vehicle and records the forward and its outcome in the DecisionLog, if it is not nil.
+func (subscription *Subscription) forwardDecision(datagram *api.UpdateVehicleDecisionDatagram, address *net.UDPAddr, decisionLog *audit.DecisionLog, decisionId string) {
+	if decisionLog == nil {
+		subscription.Connection.WriteReliableDatagram(datagram, address, subscription.Topic, true)
+		return
+	}
 
+	if address == nil {
+		address = subscription.Connection.GetClientAddress(true)
 	}
+	record := audit.Record{
+		DecisionId: decisionId,
+		Vin:        subscription.Topic,
+		Target:     address.String(),
+	}
+	onDone := func(index int, outcome string, attempts int) {
+		outcomeRecord := record
+		outcomeRecord.Event = outcome
+		outcomeRecord.Index = index
+		outcomeRecord.Reliable = true
+		outcomeRecord.Attempts = attempts
+		decisionLog.Write(outcomeRecord)
+	}
+	forwardedAt := time.Now()
+	tracked := subscription.Connection.WriteTrackedDatagram(datagram, address, subscription.Topic, onDone, true)
+
+	forwardedRecord := record
+	forwardedRecord.Event = audit.EventForwarded
+	forwardedRecord.Time = forwardedAt.UTC().Format(api.TimestampFormat)
+	forwardedRecord.Index = datagram.GetIndex()
+	forwardedRecord.Reliable = tracked
+	decisionLog.Write(forwardedRecord)
 }
 
-func (subscription *Subscription) SendIntervalUpdates() error {
+// SendGeofenceEvents sends every zone enter and exit event, Topic can limit the events to one zone.
+func (subscription *Subscription) SendGeofenceEvents(ctx context.Context) error {
+	dataModel := subscription.Connection.DataModel
+
+	dataModel.Lock()
+	lastSequence := dataModel.Geofence.NextSequence - 1
+	dataModel.Unlock()
+
+	for {
+		dataModel.Lock()
+		events := dataModel.Geofence.EventsAfter(lastSequence)
+		for len(events) == 0 {
+			err := waitCond(ctx, dataModel.updateCondGeofence)
+			if err != nil {
+				dataModel.Unlock()
+				return err
+			}
+			events = dataModel.Geofence.EventsAfter(lastSequence)
+		}
+		dataModel.Unlock()
+
+		for _, event := range events {
+			lastSequence = event.Sequence
+			if subscription.Topic != "" && subscription.Topic != event.Zone {
+				continue
+			}
+			var datagram = &GeofenceEventDatagram{
+				BaseDatagram:  api.BaseDatagram{Type: "geofence_event"},
+				GeofenceEvent: event,
+			}
+			subscription.Connection.WriteDatagram(datagram, true)
+		}
+	}
+}
+
+func (subscription *Subscription) SendIntervalUpdates(ctx context.Context) error {
 	for {
 		// Send update
 		var datagram api.IDatagram
 		switch subscription.Topic {
 		case "vehicles":
-			datagram = &api.UpdateVehiclesDatagram{
-				BaseDatagram: api.BaseDatagram{Type: "update_vehicles"},
-				Vehicles:     subscription.Connection.DataModel.GetVehicles(true),
+			datagram = &VehiclesDatagram{
+				UpdateVehiclesDatagram: api.UpdateVehiclesDatagram{
+					BaseDatagram: api.BaseDatagram{Type: "update_vehicles"},
+					Vehicles:     subscription.Connection.DataModel.GetVehicles(true),
+				},
+				Stale: subscription.Connection.DataModel.GetStaleVehicles(true),
 			}
 		case "network-statistics":
 			var vehicles = subscription.Connection.DataModel.GetVehicles(true)
-			var networkStats []api.NetworkStatistics
+			var networkStats []NetworkStatistics
 
+			vins := make([]string, len(vehicles))
+			for i, vehicle := range vehicles {
+				vins[i] = vehicle.Vin
+			}
+			// Stats of all vehicles are read at once, vehicles with unavailable stats are left out
+			stats, _ := subscription.Connection.DataModel.StatsStore.GetMany(vins)
 			for _, vehicle := range vehicles {
-				statsPtr := redis.GetNetworkStats(vehicle.Vin)
-
-				if statsPtr != nil {
-					stats := *statsPtr
-
-					var mappedStats api.NetworkStatistics
-					mappedStats.PacketsReceived = stats.PacketsReceived
-					mappedStats.ReceiveErrors = stats.ReceiveErrors
-					mappedStats.AverageLatency = int64(stats.AverageLatency)
-					mappedStats.Jitter = int64(stats.Jitter)
-
-					networkStats = append(networkStats, mappedStats)
+				if statsPtr, ok := stats[vehicle.Vin]; ok {
+					networkStats = append(networkStats, NewNetworkStatistics(vehicle.Vin, statsPtr))
 				}
 			}
-			datagram = &api.NetworkStatisticsDatagram{
+			datagram = &NetworkStatisticsDatagram{
 				BaseDatagram:      api.BaseDatagram{Type: "update_vehicles"},
 				NetworkStatistics: networkStats,
 			}
+		case "processor-statistics":
+			datagram = &ProcessorStatisticsDatagram{
+				BaseDatagram:        api.BaseDatagram{Type: "update_processor_statistics"},
+				ProcessorStatistics: subscription.Connection.DataModel.GetProcessorStatistics(true),
+			}
 		default:
 			return fmt.Errorf("unsupported content of subscription: %v", subscription.Content)
 		}
 
-		subscription.Connection.WriteDatagram(datagram, true)
+		// Both the vehicles and the network statistics are sent as update_vehicles, the topic keeps them apart
+		subscription.Connection.WriteReliableDatagram(datagram, nil, subscription.Topic, true)
 
 		// Wait for next interval
 		select {
-		case stop := <-subscription.StopSignal:
-			if stop {
-				return nil
-			}
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(time.Duration(subscription.Interval * float32(time.Second))):
 		}
 	}
@@ -0,0 +1,285 @@
+package communication
+
+import (
+	"car-integration/models"
+	"encoding/json"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// waitDone fails the test if the subscription does not end within a second.
+func waitDone(t *testing.T, subscription *Subscription) {
+	t.Helper()
+	select {
+	case <-subscription.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("subscription %v (%v) did not end", subscription.Content, subscription.Topic)
+	}
+}
+
+// expectSilence fails the test if the client receives a datagram within the duration.
+func expectSilence(t *testing.T, client *net.UDPConn, duration time.Duration) {
+	t.Helper()
+	buffer := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(duration))
+	n, _, err := client.ReadFromUDP(buffer)
+	if err == nil {
+		t.Fatalf("unexpected datagram %s", buffer[:n])
+	}
+}
+
+// readDecision reads the next datagram of the client and returns the decision it carries.
+func readDecision(t *testing.T, client *net.UDPConn) api.UpdateVehicleDecision {
+	t.Helper()
+	buffer := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("no decision: %v", err)
+	}
+	var datagram api.UpdateVehicleDecisionDatagram
+	err = json.Unmarshal(buffer[:n], &datagram)
+	if err != nil {
+		t.Fatalf("expected decision, got %s", buffer[:n])
+	}
+	return datagram.VehicleDecision
+}
+
+func updateDecision(dataModel *DataModel, vin string, message string) {
+	dataModel.UpdateVehicleDecision(nil, &api.UpdateVehicleDecisionDatagram{
+		BaseDatagram:    api.BaseDatagram{Type: "decision_update", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+		VehicleDecision: api.UpdateVehicleDecision{Vin: vin, Message: message},
+	}, true)
+}
+
+func TestCancelledSubscriptionDoesNotResendDecision(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	first, firstClient := newTestProcessorConnection(t, dataModel)
+	second, _ := newTestProcessorConnection(t, dataModel)
+
+	subscription := NewSubscription(&first.Connection, "decision-update", "VIN1", 1)
+	other := NewSubscription(&second.Connection, "decision-update", "VIN2", 1)
+	go subscription.Start()
+	go other.Start()
+	defer func() {
+		subscription.Stop(ErrUnsubscribed)
+		waitDone(t, subscription)
+	}()
+	time.Sleep(50 * time.Millisecond) // Let both subscriptions wait for decisions
+
+	updateDecision(dataModel, "VIN1", "stop")
+	buffer := make([]byte, 65536)
+	_ = firstClient.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := firstClient.ReadFromUDP(buffer); err != nil {
+		t.Fatalf("decision was not forwarded: %v", err)
+	}
+
+	other.Stop(ErrUnsubscribed)
+	waitDone(t, other)
+	expectSilence(t, firstClient, 200*time.Millisecond)
+}
+
+func TestDeleteConnectionEndsSubscriptions(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	dataModel := NewDataModel(&models.Area{}, 5)
+	manager := NewConnectionsManager(dataModel, "processor", 0, nil)
+	connection, _ := newTestProcessorConnection(t, dataModel)
+	manager.Connections[connection.GetClientAddress(true).String()] = connection
+
+	for _, datagram := range []api.SubscribeDatagram{
+		{Content: "periodic-updates", Topic: "vehicles", Interval: 0.05},
+		{Content: "live-updates"},
+		{Content: "geofence-events"},
+		{Content: "decision-update", Topic: "VIN1"},
+	} {
+		connection.Subscribe(&SubscribeDatagram{SubscribeDatagram: datagram}, true)
+	}
+	subscriptions := connection.GetSubscriptions(true)
+	if len(subscriptions) != 4 {
+		t.Fatalf("%v subscriptions, expected 4", len(subscriptions))
+	}
+
+	manager.DeleteConnection(connection, true)
+	for _, subscription := range subscriptions {
+		waitDone(t, subscription)
+		if !errors.Is(subscription.Err(), ErrConnectionDead) {
+			t.Errorf("subscription %v ended with %v, expected %v", subscription.Content, subscription.Err(), ErrConnectionDead)
+		}
+	}
+
+	// The goroutines return right after closing Done
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v goroutines left running, %v before the subscriptions", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDecisionOfRemovedVehicleIsSkipped(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	connection, client := newTestProcessorConnection(t, dataModel)
+
+	subscription := NewSubscription(&connection.Connection, "decision-update", "VIN1", 1)
+	go subscription.Start()
+	defer func() {
+		subscription.Stop(ErrUnsubscribed)
+		waitDone(t, subscription)
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the subscription wait for decisions
+
+	// The vehicle is removed before the woken subscription gets the lock
+	dataModel.Lock()
+	dataModel.UpdateVehicleDecision(nil, &api.UpdateVehicleDecisionDatagram{
+		BaseDatagram:    api.BaseDatagram{Type: "decision_update", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+		VehicleDecision: api.UpdateVehicleDecision{Vin: "VIN1", Message: "stop"},
+	}, false)
+	dataModel.RemoveVehicle("VIN1", GeofenceReasonRemoved, false)
+	dataModel.Unlock()
+
+	expectSilence(t, client, 200*time.Millisecond)
+	if err := subscription.Err(); err != nil {
+		t.Fatalf("subscription ended with %v", err)
+	}
+
+	updateDecision(dataModel, "VIN1", "go")
+	buffer := make([]byte, 65536)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := client.ReadFromUDP(buffer); err != nil {
+		t.Fatalf("decision after the removal was not forwarded: %v", err)
+	}
+}
+
+func TestDecisionsOfSeveralVehiclesBetweenWakeupsAreForwarded(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	vins := []string{"VIN1", "VIN2", "VIN3"}
+	clients := make([]*net.UDPConn, len(vins))
+	for i, vin := range vins {
+		connection, client := newTestProcessorConnection(t, dataModel)
+		clients[i] = client
+		subscription := NewSubscription(&connection.Connection, "decision-update", vin, 1)
+		go subscription.Start()
+		defer func() {
+			subscription.Stop(ErrUnsubscribed)
+			waitDone(t, subscription)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // Let the subscriptions wait for decisions
+
+	// All decisions land before any subscription gets the lock back
+	dataModel.Lock()
+	for _, vin := range vins {
+		dataModel.UpdateVehicleDecision(nil, &api.UpdateVehicleDecisionDatagram{
+			BaseDatagram:    api.BaseDatagram{Type: "decision_update", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+			VehicleDecision: api.UpdateVehicleDecision{Vin: vin, Message: "stop"},
+		}, false)
+	}
+	dataModel.Unlock()
+
+	buffer := make([]byte, 65536)
+	for i, client := range clients {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := client.ReadFromUDP(buffer); err != nil {
+			t.Errorf("decision for %v was not forwarded: %v", vins[i], err)
+		}
+	}
+}
+
+func TestHandedOffDecisionIsForwarded(t *testing.T) {
+	dataModel := NewDataModel(&models.Area{}, 5)
+	before, beforeClient := newTestProcessorConnection(t, dataModel)
+	after, afterClient := newTestProcessorConnection(t, dataModel)
+
+	// One vehicle is connected before the hand-off arrives, the other reconnects after it
+	waiting := NewSubscription(&before.Connection, "decision-update", "VIN1", 1)
+	go waiting.Start()
+	defer func() {
+		waiting.Stop(ErrUnsubscribed)
+		waitDone(t, waiting)
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the subscription wait for decisions
+
+	dataModel.AcceptHandoff(&HandoffVehicleDatagram{
+		BaseDatagram:      api.BaseDatagram{Type: "handoff_vehicle"},
+		Vehicle:           api.UpdateVehicleVehicle{Vin: "VIN1"},
+		VehicleTimestamp:  time.Now().UTC().Format(api.TimestampFormat),
+		VehicleDecision:   &api.UpdateVehicleDecision{Vin: "VIN1", Message: "stop"},
+		DecisionTimestamp: time.Now().UTC().Format(api.TimestampFormat),
+	}, true)
+	if decision := readDecision(t, beforeClient); decision.Message != "stop" {
+		t.Errorf("waiting subscription forwarded %+v, expected the handed off decision", decision)
+	}
+
+	subscribed := NewSubscription(&after.Connection, "decision-update", "VIN1", 1)
+	go subscribed.Start()
+	defer func() {
+		subscribed.Stop(ErrUnsubscribed)
+		waitDone(t, subscribed)
+	}()
+	if decision := readDecision(t, afterClient); decision.Message != "stop" {
+		t.Errorf("subscription after the hand-off forwarded %+v, expected the handed off decision", decision)
+	}
+	expectSilence(t, afterClient, 200*time.Millisecond)
+}
+
+func TestRemovedVehicleExitsZones(t *testing.T) {
+	zone := models.Zone{Name: "pit-lane", Kind: "pit", Polygons: []models.Polygon{{Rings: [][]api.PositionJSON{
+		{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 1}, {Lat: 1, Lon: 1}, {Lat: 1, Lon: 0}},
+	}}}}
+	dataModel := NewDataModel(&models.Area{Zones: []models.Zone{zone}}, 5)
+	connection, client := newTestProcessorConnection(t, dataModel)
+
+	subscription := NewSubscription(&connection.Connection, "geofence-events", "", 1)
+	go subscription.Start()
+	defer func() {
+		subscription.Stop(ErrUnsubscribed)
+		waitDone(t, subscription)
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the subscription wait for events
+
+	for _, vin := range []string{"VIN1", "VIN2"} {
+		dataModel.UpdateVehicle(nil, &api.UpdateVehicleDatagram{
+			BaseDatagram: api.BaseDatagram{Type: "update_vehicle", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+			Vehicle:      api.UpdateVehicleVehicle{Vin: vin, Latitude: 0.5, Longitude: 0.5},
+		}, true)
+	}
+	dataModel.DeleteVehicle("VIN1", GeofenceReasonDisconnected, true)
+	dataModel.RemoveVehicle("VIN2", GeofenceReasonHandedOff, true)
+
+	expected := []GeofenceEvent{
+		{Vin: "VIN1", Direction: GeofenceEnter},
+		{Vin: "VIN2", Direction: GeofenceEnter},
+		{Vin: "VIN1", Direction: GeofenceExit, Reason: GeofenceReasonDisconnected},
+		{Vin: "VIN2", Direction: GeofenceExit, Reason: GeofenceReasonHandedOff},
+	}
+	buffer := make([]byte, 65536)
+	for _, want := range expected {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := client.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("no event %+v: %v", want, err)
+		}
+		var datagram GeofenceEventDatagram
+		_ = json.Unmarshal(buffer[:n], &datagram)
+		event := datagram.GeofenceEvent
+		if event.Vin != want.Vin || event.Direction != want.Direction || event.Reason != want.Reason ||
+			event.Zone != "pit-lane" || event.ZoneKind != "pit" || event.Position.Lat != 0.5 {
+			t.Errorf("event %+v, expected %v %v %q of pit-lane at the last position", event, want.Vin, want.Direction, want.Reason)
+		}
+	}
+
+	// Vehicles outside of all zones leave without events
+	dataModel.UpdateVehicle(nil, &api.UpdateVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "update_vehicle", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+		Vehicle:      api.UpdateVehicleVehicle{Vin: "VIN3", Latitude: 5, Longitude: 5},
+	}, true)
+	dataModel.DeleteVehicle("VIN3", GeofenceReasonDisconnected, true)
+	expectSilence(t, client, 200*time.Millisecond)
+}
@@ -0,0 +1,387 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Listener types, see communication.NewConnectionsManager
+const (
+	ListenerProcessor = "processor"
+	ListenerVehicle   = "vehicle"
+)
+
+type Config struct {
+	Listeners        []ListenerConfig       `yaml:"listeners"`
+	Redis            RedisConfig            `yaml:"redis"`
+	Database         DatabaseConfig         `yaml:"database"`
+	Sentry           SentryConfig           `yaml:"sentry"`
+	Area             AreaConfig             `yaml:"area"`
+	Routing          RoutingConfig          `yaml:"routing"`
+	DataModel        DataModelConfig        `yaml:"data_model"`
+	ReliableDelivery ReliableDeliveryConfig `yaml:"reliable_delivery"`
+	Audit            AuditConfig            `yaml:"audit"`
+	NetworkStats     NetworkStatsConfig     `yaml:"network_stats"`
+	Snapshot         SnapshotConfig         `yaml:"snapshot"`
+	AllowedVins      []string               `yaml:"allowed_vins"`     // VINs of vehicles accepted by the module, empty to accept all
+	DebugAddress     string                 `yaml:"debug_address"`    // pprof, metrics and admin API
+	HealthAddress    string                 `yaml:"health_address"`   // /healthz and /readyz for the container orchestrator
+	ShutdownTimeout  time.Duration          `yaml:"shutdown_timeout"` // Deadline of the graceful shutdown, the module exits forcefully after it
+}
+
+// ListenerConfig describes one UDP listener with its ConnectionsManager.
+type ListenerConfig struct {
+	Name             string        `yaml:"name"`
+	Type             string        `yaml:"type"` // ListenerProcessor or ListenerVehicle
+	BindAddress      string        `yaml:"bind_address"`
+	Port             int           `yaml:"port"`
+	KeepAliveTimeout float32       `yaml:"keep_alive_timeout"` // Seconds, 0 for no timeout
+	InputLogPath     string        `yaml:"input_log_path"`     // Empty to disable logging of incoming datagrams
+	PingInterval     time.Duration `yaml:"ping_interval"`      // 0 to disable pings
+	ReliableDelivery bool          `yaml:"reliable_delivery"`  // Retransmit datagrams until acknowledged, see ReliableDeliveryConfig
+}
+
+type RedisConfig struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+type DatabaseConfig struct {
+	DSN                    string        `yaml:"dsn"`                      // Empty to disable telemetry storage
+	TelemetryBufferSize    int           `yaml:"telemetry_buffer_size"`    // Rows kept in memory while the database is down, the oldest are dropped
+	TelemetryBatchSize     int           `yaml:"telemetry_batch_size"`     // Rows inserted at once
+	TelemetryFlushInterval time.Duration `yaml:"telemetry_flush_interval"` // Interval of inserts
+}
+
+// Network statistics stores
+const (
+	StatsStoreRedis  = "redis"
+	StatsStoreMemory = "memory"
+)
+
+type NetworkStatsConfig struct {
+	Store         string        `yaml:"store"`          // redis, or memory if Redis is not deployed
+	Namespace     string        `yaml:"namespace"`      // Prefix of the Redis keys, followed by the VIN
+	TTL           time.Duration `yaml:"ttl"`            // Expiry of the stats of a vehicle which stopped sending, 0 for none
+	FlushInterval time.Duration `yaml:"flush_interval"` // Interval of the batched writes to Redis
+}
+
+// Snapshot stores
+const (
+	SnapshotStoreFile  = "file"
+	SnapshotStoreRedis = "redis"
+)
+
+type SnapshotConfig struct {
+	Store        string        `yaml:"store"`         // file, redis, or empty to disable snapshots
+	Path         string        `yaml:"path"`          // File of the file store
+	Key          string        `yaml:"key"`           // Key of the redis store
+	Interval     time.Duration `yaml:"interval"`      // Interval of the snapshots
+	MaxAge       time.Duration `yaml:"max_age"`       // Older snapshots are not restored on start
+	StaleTimeout time.Duration `yaml:"stale_timeout"` // Restored vehicles which do not send an update within it are removed
+}
+
+type AuditConfig struct {
+	DecisionLogPath string `yaml:"decision_log_path"` // JSON Lines file of received and forwarded decisions, empty to disable
+}
+
+type SentryConfig struct {
+	DSN              string  `yaml:"dsn"` // Empty to disable Sentry
+	TracesSampleRate float64 `yaml:"traces_sample_rate"`
+}
+
+type PositionConfig struct {
+	Lat float32 `yaml:"lat"`
+	Lon float32 `yaml:"lon"`
+}
+
+type AreaConfig struct {
+	TopLeft        PositionConfig `yaml:"top_left"`
+	BottomRight    PositionConfig `yaml:"bottom_right"`
+	ZonesFile      string         `yaml:"zones_file"`      // GeoJSON with the area polygon and zones, overrides the corners
+	NeighboursFile string         `yaml:"neighbours_file"` // JSON list of neighbouring instances for hand-off
+}
+
+type RoutingConfig struct {
+	Routes     string `yaml:"routes"`      // VIN=host:port separated by ;
+	RoutesFile string `yaml:"routes_file"` // JSON mapping VIN to route
+}
+
+type DataModelConfig struct {
+	NotificationDuration  float32       `yaml:"notification_duration"`
+	HistoryDepth          int           `yaml:"history_depth"`
+	HistoryDuration       time.Duration `yaml:"history_duration"`
+	UpdateQueueCapacity   int           `yaml:"update_queue_capacity"`
+	UpdateQueueOverflow   string        `yaml:"update_queue_overflow"`
+	GeofenceConfirmations int           `yaml:"geofence_confirmations"`
+}
+
+type ReliableDeliveryConfig struct {
+	RetransmitTimeout    time.Duration `yaml:"retransmit_timeout"`
+	MaxRetransmitTimeout time.Duration `yaml:"max_retransmit_timeout"`
+	Deadline             time.Duration `yaml:"deadline"`
+}
+
+// Default returns the configuration the Integration Module was deployed with before it was configurable.
+func Default() *Config {
+	return &Config{
+		Listeners: []ListenerConfig{
+			{Name: "decision-module", Type: ListenerProcessor, BindAddress: "0.0.0.0", Port: 6060, PingInterval: time.Second},
+			{Name: "backend", Type: ListenerProcessor, BindAddress: "0.0.0.0", Port: 5050, PingInterval: time.Second},
+			{Name: "vehicles", Type: ListenerVehicle, BindAddress: "0.0.0.0", Port: 4040, PingInterval: time.Second, ReliableDelivery: true},
+			{Name: "free-processor", Type: ListenerProcessor, BindAddress: "0.0.0.0", Port: 4041, PingInterval: time.Second},
+		},
+		Redis: RedisConfig{
+			Address: "redis:6379",
+		},
+		Database: DatabaseConfig{
+			TelemetryBufferSize:    100000,
+			TelemetryBatchSize:     500,
+			TelemetryFlushInterval: time.Second,
+		},
+		Sentry: SentryConfig{
+			TracesSampleRate: 1.0,
+		},
+		DataModel: DataModelConfig{
+			HistoryDepth:          1200,
+			HistoryDuration:       60 * time.Second,
+			UpdateQueueCapacity:   256,
+			UpdateQueueOverflow:   "coalesce",
+			GeofenceConfirmations: 3,
+		},
+		ReliableDelivery: ReliableDeliveryConfig{
+			RetransmitTimeout:    100 * time.Millisecond,
+			MaxRetransmitTimeout: 800 * time.Millisecond,
+			Deadline:             3 * time.Second,
+		},
+		DebugAddress:    "localhost:3030",
+		HealthAddress:   "0.0.0.0:3031",
+		ShutdownTimeout: 10 * time.Second,
+		Audit: AuditConfig{
+			DecisionLogPath: "decisions.jsonl",
+		},
+		Snapshot: SnapshotConfig{
+			Store:        SnapshotStoreFile,
+			Path:         "snapshot.json",
+			Key:          "car-integration:snapshot",
+			Interval:     5 * time.Second,
+			MaxAge:       10 * time.Minute,
+			StaleTimeout: time.Minute,
+		},
+		NetworkStats: NetworkStatsConfig{
+			Store:         StatsStoreRedis,
+			Namespace:     "car-integration:network-stats:",
+			TTL:           time.Hour,
+			FlushInterval: time.Second,
+		},
+	}
+}
+
+// Load reads the YAML file over the defaults (skipped if the path is empty), applies environment overrides and validates the result.
+func Load(filepath string) (*Config, error) {
+	config := Default()
+
+	if filepath != "" {
+		data, err := os.ReadFile(filepath)
+		if err != nil {
+			return nil, err
+		}
+		// Misspelled keys would silently keep the defaults
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(config)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %v: %w", filepath, err)
+		}
+	}
+
+	err := config.applyEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return config, nil
+}
+
+// applyEnvironment overrides the values by the environment variables, which are set.
+func (config *Config) applyEnvironment() error {
+	values := map[string]*string{
+		"REDIS_ADDRESS":       &config.Redis.Address,
+		"REDIS_PASSWORD":      &config.Redis.Password,
+		"DATABASE_DSN":        &config.Database.DSN,
+		"SENTRY_DSN":          &config.Sentry.DSN,
+		"ZONES_FILE":          &config.Area.ZonesFile,
+		"NEIGHBOURS_FILE":     &config.Area.NeighboursFile,
+		"VEHICLE_ROUTES":      &config.Routing.Routes,
+		"VEHICLE_ROUTES_FILE": &config.Routing.RoutesFile,
+		"DEBUG_ADDRESS":       &config.DebugAddress,
+		"HEALTH_ADDRESS":      &config.HealthAddress,
+	}
+	for name, value := range values {
+		if environmentValue, ok := os.LookupEnv(name); ok {
+			*value = environmentValue
+		}
+	}
+
+	if environmentValue, ok := os.LookupEnv("REDIS_DB"); ok {
+		db, err := strconv.Atoi(environmentValue)
+		if err != nil {
+			return fmt.Errorf("invalid REDIS_DB %q: %w", environmentValue, err)
+		}
+		config.Redis.DB = db
+	}
+
+	// Listeners are overridden by LISTENER_<NAME>_<SETTING>, the name is upper case with dashes replaced by underscores
+	for i := range config.Listeners {
+		listener := &config.Listeners[i]
+		prefix := "LISTENER_" + strings.ToUpper(strings.ReplaceAll(listener.Name, "-", "_")) + "_"
+
+		if environmentValue, ok := os.LookupEnv(prefix + "BIND_ADDRESS"); ok {
+			listener.BindAddress = environmentValue
+		}
+		if environmentValue, ok := os.LookupEnv(prefix + "PORT"); ok {
+			port, err := strconv.Atoi(environmentValue)
+			if err != nil {
+				return fmt.Errorf("invalid %vPORT %q: %w", prefix, environmentValue, err)
+			}
+			listener.Port = port
+		}
+		if environmentValue, ok := os.LookupEnv(prefix + "KEEP_ALIVE_TIMEOUT"); ok {
+			timeout, err := strconv.ParseFloat(environmentValue, 32)
+			if err != nil {
+				return fmt.Errorf("invalid %vKEEP_ALIVE_TIMEOUT %q: %w", prefix, environmentValue, err)
+			}
+			listener.KeepAliveTimeout = float32(timeout)
+		}
+	}
+	return nil
+}
+
+// UsesRedis reports whether the network statistics or the snapshots are stored in Redis.
+func (config *Config) UsesRedis() bool {
+	return config.NetworkStats.Store == StatsStoreRedis || config.Snapshot.Store == SnapshotStoreRedis
+}
+
+// Validate returns all problems of the configuration joined together.
+func (config *Config) Validate() error {
+	var problems []error
+
+	if len(config.Listeners) == 0 {
+		problems = append(problems, errors.New("no listeners configured"))
+	}
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i, listener := range config.Listeners {
+		if listener.Name == "" {
+			problems = append(problems, fmt.Errorf("listener %v has no name", i))
+		} else if names[listener.Name] {
+			problems = append(problems, fmt.Errorf("listener name %q is not unique", listener.Name))
+		}
+		names[listener.Name] = true
+
+		if listener.Type != ListenerProcessor && listener.Type != ListenerVehicle {
+			problems = append(problems, fmt.Errorf("listener %q has invalid type %q, expected %v or %v",
+				listener.Name, listener.Type, ListenerProcessor, ListenerVehicle))
+		}
+		if listener.Port <= 0 || listener.Port > 65535 {
+			problems = append(problems, fmt.Errorf("listener %q has invalid port %v", listener.Name, listener.Port))
+		}
+		if net.ParseIP(listener.BindAddress) == nil {
+			problems = append(problems, fmt.Errorf("listener %q has invalid bind address %q", listener.Name, listener.BindAddress))
+		}
+		address := net.JoinHostPort(listener.BindAddress, strconv.Itoa(listener.Port))
+		if addresses[address] {
+			problems = append(problems, fmt.Errorf("listener %q uses %v of another listener", listener.Name, address))
+		}
+		addresses[address] = true
+
+		if listener.KeepAliveTimeout < 0 {
+			problems = append(problems, fmt.Errorf("listener %q has negative keep alive timeout", listener.Name))
+		}
+		if listener.PingInterval < 0 {
+			problems = append(problems, fmt.Errorf("listener %q has negative ping interval", listener.Name))
+		}
+	}
+
+	if config.UsesRedis() && config.Redis.Address == "" {
+		problems = append(problems, errors.New("redis address is empty"))
+	}
+	if config.NetworkStats.Store != StatsStoreRedis && config.NetworkStats.Store != StatsStoreMemory {
+		problems = append(problems, fmt.Errorf("invalid network stats store %q, expected %v or %v",
+			config.NetworkStats.Store, StatsStoreRedis, StatsStoreMemory))
+	}
+	snapshot := config.Snapshot
+	switch snapshot.Store {
+	case "":
+	case SnapshotStoreFile, SnapshotStoreRedis:
+		if snapshot.Store == SnapshotStoreFile && snapshot.Path == "" || snapshot.Store == SnapshotStoreRedis && snapshot.Key == "" {
+			problems = append(problems, fmt.Errorf("snapshot store %v needs a path or key", snapshot.Store))
+		}
+		if snapshot.Interval <= 0 || snapshot.MaxAge <= 0 || snapshot.StaleTimeout < 0 {
+			problems = append(problems, errors.New("snapshot interval and max age must be positive and stale timeout not negative"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("invalid snapshot store %q, expected %v, %v or empty",
+			snapshot.Store, SnapshotStoreFile, SnapshotStoreRedis))
+	}
+	if config.NetworkStats.TTL < 0 || config.NetworkStats.FlushInterval <= 0 {
+		problems = append(problems, errors.New("network stats ttl must not be negative and flush interval must be positive"))
+	}
+	if config.Sentry.TracesSampleRate < 0 || config.Sentry.TracesSampleRate > 1 {
+		problems = append(problems, fmt.Errorf("sentry traces sample rate %v is not between 0 and 1", config.Sentry.TracesSampleRate))
+	}
+	if config.Area.ZonesFile == "" && config.Area.TopLeft == config.Area.BottomRight {
+		problems = append(problems, errors.New("area is empty, set its corners or zones file"))
+	}
+	if config.Area.TopLeft.Lat < config.Area.BottomRight.Lat {
+		problems = append(problems, errors.New("area top left corner is below the bottom right corner"))
+	}
+
+	if config.Database.TelemetryBufferSize <= 0 || config.Database.TelemetryBatchSize <= 0 || config.Database.TelemetryFlushInterval <= 0 {
+		problems = append(problems, errors.New("database telemetry buffer size, batch size and flush interval must be positive"))
+	}
+
+	if config.DataModel.HistoryDepth <= 0 {
+		problems = append(problems, errors.New("data model history depth must be positive"))
+	}
+	if config.DataModel.HistoryDuration < 0 {
+		problems = append(problems, errors.New("data model history duration is negative"))
+	}
+	if config.DataModel.UpdateQueueCapacity <= 0 {
+		problems = append(problems, errors.New("data model update queue capacity must be positive"))
+	}
+	if config.DataModel.UpdateQueueOverflow != "coalesce" && config.DataModel.UpdateQueueOverflow != "drop-oldest" {
+		problems = append(problems, fmt.Errorf("invalid update queue overflow %q, expected coalesce or drop-oldest",
+			config.DataModel.UpdateQueueOverflow))
+	}
+	if config.DataModel.GeofenceConfirmations <= 0 {
+		problems = append(problems, errors.New("data model geofence confirmations must be positive"))
+	}
+
+	delivery := config.ReliableDelivery
+	if delivery.RetransmitTimeout <= 0 || delivery.MaxRetransmitTimeout < delivery.RetransmitTimeout || delivery.Deadline <= 0 {
+		problems = append(problems, errors.New("reliable delivery timeouts must be positive and max retransmit timeout at least retransmit timeout"))
+	}
+
+	if config.DebugAddress == "" || config.HealthAddress == "" {
+		problems = append(problems, errors.New("debug and health addresses must be set"))
+	}
+	if config.ShutdownTimeout <= 0 {
+		problems = append(problems, errors.New("shutdown timeout must be positive"))
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validArea is the smallest file passing validation, the defaults have an empty area.
+const validArea = `
+area:
+  top_left: {lat: 48.16, lon: 17.06}
+  bottom_right: {lat: 48.15, lon: 17.08}
+`
+
+// writeConfig writes the YAML to a temporary file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// validConfig returns the defaults with a non-empty area.
+func validConfig() *Config {
+	config := Default()
+	config.Area.TopLeft = PositionConfig{Lat: 48.16, Lon: 17.06}
+	config.Area.BottomRight = PositionConfig{Lat: 48.15, Lon: 17.08}
+	return config
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+		check   func(t *testing.T, config *Config)
+	}{
+		{"file over defaults", validArea + "redis: {db: 3}\nshutdown_timeout: 2s\n", "", func(t *testing.T, config *Config) {
+			if config.Redis.DB != 3 || config.Redis.Address != "redis:6379" {
+				t.Errorf("Redis = %+v, want db 3 with the default address", config.Redis)
+			}
+			if config.ShutdownTimeout != 2*time.Second {
+				t.Errorf("ShutdownTimeout = %v, want 2s", config.ShutdownTimeout)
+			}
+			if len(config.Listeners) != len(Default().Listeners) {
+				t.Errorf("%v listeners, want the %v defaults", len(config.Listeners), len(Default().Listeners))
+			}
+		}},
+		{"listeners replace defaults", validArea + "listeners:\n  - {name: cars, type: vehicle, bind_address: 127.0.0.1, port: 7070}\n", "", func(t *testing.T, config *Config) {
+			if len(config.Listeners) != 1 || config.Listeners[0].Name != "cars" || config.Listeners[0].Port != 7070 {
+				t.Errorf("Listeners = %+v, want only cars on 7070", config.Listeners)
+			}
+		}},
+		{"unknown key", validArea + "shutdown_timout: 2s\n", "shutdown_timout", nil},
+		{"unknown nested key", validArea + "redis: {adress: localhost:6379}\n", "adress", nil},
+		{"malformed", validArea + "listeners: {\n", "parsing", nil},
+		{"empty file keeps invalid defaults", "", "area is empty", nil},
+		{"invalid value", validArea + "data_model: {history_depth: 0}\n", "history depth", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := Load(writeConfig(t, test.content))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Load() error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			test.check(t, config)
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Load() error = %v, want not exist", err)
+	}
+}
+
+func TestLoadShippedConfig(t *testing.T) {
+	if _, err := Load("../../config.yaml"); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(config *Config)
+		wantErr string
+	}{
+		{"valid", func(config *Config) {}, ""},
+		{"no listeners", func(config *Config) { config.Listeners = nil }, "no listeners"},
+		{"duplicate name", func(config *Config) { config.Listeners[1].Name = config.Listeners[0].Name }, "not unique"},
+		{"duplicate address", func(config *Config) { config.Listeners[1].Port = config.Listeners[0].Port }, "of another listener"},
+		{"invalid type", func(config *Config) { config.Listeners[0].Type = "car" }, "invalid type"},
+		{"invalid port", func(config *Config) { config.Listeners[0].Port = 70000 }, "invalid port"},
+		{"invalid bind address", func(config *Config) { config.Listeners[0].BindAddress = "localhost" }, "invalid bind address"},
+		{"negative keep alive timeout", func(config *Config) { config.Listeners[0].KeepAliveTimeout = -1 }, "negative keep alive"},
+		{"empty area", func(config *Config) { config.Area = AreaConfig{} }, "area is empty"},
+		{"zones file without corners", func(config *Config) { config.Area = AreaConfig{ZonesFile: "zones.geojson"} }, ""},
+		{"flipped area", func(config *Config) {
+			config.Area.TopLeft, config.Area.BottomRight = config.Area.BottomRight, config.Area.TopLeft
+		}, "below the bottom right"},
+		{"invalid stats store", func(config *Config) { config.NetworkStats.Store = "file" }, "invalid network stats store"},
+		{"redis without address", func(config *Config) { config.Redis.Address = "" }, "redis address is empty"},
+		{"memory without redis address", func(config *Config) {
+			config.Redis.Address = ""
+			config.NetworkStats.Store = StatsStoreMemory
+		}, ""},
+		{"invalid snapshot store", func(config *Config) { config.Snapshot.Store = "s3" }, "invalid snapshot store"},
+		{"snapshot file without path", func(config *Config) { config.Snapshot.Path = "" }, "needs a path or key"},
+		{"disabled snapshots", func(config *Config) { config.Snapshot = SnapshotConfig{} }, ""},
+		{"sample rate above 1", func(config *Config) { config.Sentry.TracesSampleRate = 2 }, "sample rate"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			test.modify(config)
+			err := config.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	file := validArea + "redis: {address: file:6379, password: secret, db: 1}\n"
+
+	tests := []struct {
+		name        string
+		environment map[string]string
+		wantErr     string
+		check       func(t *testing.T, config *Config)
+	}{
+		{"file without environment", nil, "", func(t *testing.T, config *Config) {
+			if config.Redis.Address != "file:6379" || config.Redis.DB != 1 {
+				t.Errorf("Redis = %+v, want the file values", config.Redis)
+			}
+		}},
+		{"environment over file", map[string]string{"REDIS_ADDRESS": "env:6379", "REDIS_DB": "2"}, "", func(t *testing.T, config *Config) {
+			if config.Redis.Address != "env:6379" || config.Redis.DB != 2 {
+				t.Errorf("Redis = %+v, want the environment values", config.Redis)
+			}
+		}},
+		{"empty value overrides", map[string]string{"REDIS_PASSWORD": ""}, "", func(t *testing.T, config *Config) {
+			if config.Redis.Password != "" {
+				t.Errorf("Redis.Password = %q, want empty", config.Redis.Password)
+			}
+		}},
+		{"sentry dsn", map[string]string{"SENTRY_DSN": "https://key@sentry.example/1"}, "", func(t *testing.T, config *Config) {
+			if config.Sentry.DSN != "https://key@sentry.example/1" {
+				t.Errorf("Sentry.DSN = %q", config.Sentry.DSN)
+			}
+		}},
+		{"listener", map[string]string{
+			"LISTENER_DECISION_MODULE_PORT":               "6161",
+			"LISTENER_DECISION_MODULE_BIND_ADDRESS":       "127.0.0.1",
+			"LISTENER_DECISION_MODULE_KEEP_ALIVE_TIMEOUT": "2.5",
+		}, "", func(t *testing.T, config *Config) {
+			listener := config.Listeners[0]
+			if listener.Port != 6161 || listener.BindAddress != "127.0.0.1" || listener.KeepAliveTimeout != 2.5 {
+				t.Errorf("decision-module = %+v, want the environment values", listener)
+			}
+			if config.Listeners[1].Port != 5050 {
+				t.Errorf("backend port = %v, want it unchanged", config.Listeners[1].Port)
+			}
+		}},
+		{"invalid redis db", map[string]string{"REDIS_DB": "one"}, "REDIS_DB", nil},
+		{"invalid listener port", map[string]string{"LISTENER_BACKEND_PORT": "http"}, "LISTENER_BACKEND_PORT", nil},
+		{"invalid listener timeout", map[string]string{"LISTENER_VEHICLES_KEEP_ALIVE_TIMEOUT": "1m"}, "LISTENER_VEHICLES_KEEP_ALIVE_TIMEOUT", nil},
+		{"overridden value is validated", map[string]string{"LISTENER_BACKEND_PORT": "6060"}, "of another listener", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.environment {
+				t.Setenv(name, value)
+			}
+			config, err := Load(writeConfig(t, file))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Load() error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			test.check(t, config)
+		})
+	}
+}
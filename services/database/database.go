@@ -27,9 +27,13 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/getsentry/sentry-go"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -37,31 +41,112 @@ import (
 var DB *gorm.DB
 var DBerr error
 
-func DBConnect() *gorm.DB {
-	// ✅ Use correct host and port for your Docker host
-	dsn := "host=127.0.0.1 user=postgres password=postgres dbname=postgres port=5555 sslmode=disable"
+var (
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+)
+
+// retryInterval is the delay between connection attempts.
+const retryInterval = 3 * time.Second
+
+// Open opens the connection without migrating the schema, e.g. for read-only queries.
+func Open(dsn string) (*gorm.DB, error) {
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
 
-	// Try several times before giving up
-	for i := 0; i < 10; i++ {
-		DB, DBerr = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if DBerr == nil {
+// Connect opens the connection and migrates the schema.
+func Connect(dsn string) (*gorm.DB, error) {
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = Migrate(db)
+	if err != nil {
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+	return db, nil
+}
+
+// DBConnect tries to connect until it succeeds or the context is done.
+// The module keeps working without the database in the meantime (simulation mode), telemetry is buffered.
+func DBConnect(ctx context.Context, dsn string) *gorm.DB {
+	for attempt := 1; ; attempt++ {
+		db, err := Connect(dsn)
+		mutex.Lock()
+		DB, DBerr = db, err
+		mutex.Unlock()
+		if err == nil {
 			fmt.Println("Connected to TimescaleDB successfully.")
-			return DB
+			return db
 		}
-		fmt.Printf("DB connection failed (%v); retrying...\n", DBerr)
-		time.Sleep(3 * time.Second)
-	}
 
-	// ❌ Still no success → just warn, do not crash
-	fmt.Println("Warning: proceeding without DB connection (simulation mode).")
-	DB = nil
-	return nil
+		if attempt == 1 {
+			sentry.CaptureException(err)
+			fmt.Printf("DB connection failed (%v); proceeding without DB connection (simulation mode), retrying...\n", err)
+		}
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
 }
 
 func GetDB() *gorm.DB {
+	mutex.Lock()
+	defer mutex.Unlock()
 	return DB
 }
 
-func Init() {
-	DBConnect()
+// Init connects to TimescaleDB in the background and starts the telemetry writer, see WriteTelemetry.
+func Init(dsn string, options TelemetryOptions) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	mutex.Lock()
+	cancel = cancelFunc
+	mutex.Unlock()
+
+	telemetryWriter = NewTelemetryWriter(options)
+	go DBConnect(ctx, dsn)
+	go telemetryWriter.Run(ctx)
+}
+
+// Ping checks the connectivity to TimescaleDB, used by the health endpoints.
+func Ping(ctx context.Context) error {
+	db := GetDB()
+	if db == nil {
+		return errors.New("not connected, running in simulation mode")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close flushes the buffered telemetry until the context is done and closes the connection to TimescaleDB, if there is one.
+func Close(ctx context.Context) error {
+	mutex.Lock()
+	cancelFunc := cancel
+	mutex.Unlock()
+	if cancelFunc == nil {
+		return nil
+	}
+	cancelFunc()
+
+	db := GetDB()
+	if db == nil {
+		fmt.Printf("Not connected to the database, discarding %v buffered telemetry rows\n", telemetryWriter.GetBufferedCount())
+		return nil
+	}
+
+	var errs []error
+	if err := telemetryWriter.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flushing telemetry: %w", err))
+	}
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	errs = append(errs, err)
+	return errors.Join(errs...)
 }
@@ -0,0 +1,225 @@
+package database
+
+import (
+	metrics "car-integration/services/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+	"github.com/getsentry/sentry-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Telemetry is one accepted vehicle update, stored in the vehicle_telemetry hypertable.
+// Rows are partitioned by the time of receipt, the clock of a vehicle may be skewed or reset.
+type Telemetry struct {
+	Time                  time.Time  `gorm:"primaryKey;not null"` // Time the update was received, in the clock of the module
+	Vin                   string     `gorm:"primaryKey;not null"`
+	VehicleTime           *time.Time // Timestamp of the datagram in the vehicle clock, nil if it is not valid
+	IsControlledByUser    bool
+	Latitude              float32
+	Longitude             float32
+	GpsDirection          float32
+	GpsSatelliteCount     float32
+	GpsHorizontalAccuracy float32
+	FrontUltrasonic       float32
+	FrontLidar            float32
+	RearUltrasonic        float32
+	Speed                 float32
+	SpeedFrontLeft        float32
+	SpeedFrontRight       float32
+	SpeedRearLeft         float32
+	SpeedRearRight        float32
+	Voltage0              float32
+	Voltage1              float32
+	Voltage2              float32
+}
+
+func (Telemetry) TableName() string {
+	return "vehicle_telemetry"
+}
+
+// Migrate creates the telemetry hypertable, it is safe to run on every start.
+func Migrate(db *gorm.DB) error {
+	err := db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb").Error
+	if err != nil {
+		return err
+	}
+	err = db.AutoMigrate(&Telemetry{})
+	if err != nil {
+		return err
+	}
+	return db.Exec("SELECT create_hypertable('vehicle_telemetry', 'time', if_not_exists => TRUE)").Error
+}
+
+type TelemetryOptions struct {
+	BufferSize    int           // Maximum number of rows kept while the database is slow or down, the oldest are dropped
+	BatchSize     int           // Maximum number of rows inserted at once
+	FlushInterval time.Duration // Interval of inserts of the buffered rows
+}
+
+// TelemetryWriter buffers telemetry in memory and inserts it in batches, so the UDP read loop never waits for the database.
+// The buffer is a ring growing up to the buffer size, once full every added row replaces the oldest one.
+type TelemetryWriter struct {
+	sync.Mutex
+	Options TelemetryOptions
+	Buffer  []Telemetry
+	Head    int // Position of the oldest row in the Buffer
+	Count   int // Number of buffered rows
+	Dropped int64
+	writing sync.Mutex // Serializes inserts of the writer loop and Flush
+}
+
+var telemetryWriter *TelemetryWriter
+
+func NewTelemetryWriter(options TelemetryOptions) *TelemetryWriter {
+	return &TelemetryWriter{
+		Options: options,
+		Buffer:  make([]Telemetry, min(options.BatchSize, options.BufferSize)),
+	}
+}
+
+// WriteTelemetry queues the vehicle update for insertion, it does nothing if the database is not initialized.
+func WriteTelemetry(vehicle *api.UpdateVehicleVehicle, timestamp string, receivedAt time.Time) {
+	if telemetryWriter == nil {
+		return
+	}
+	var vehicleTime *time.Time
+	if parsed, err := time.Parse(api.TimestampFormat, timestamp); err == nil {
+		vehicleTime = &parsed
+	}
+	telemetryWriter.Add(Telemetry{
+		Time:                  receivedAt,
+		Vin:                   vehicle.Vin,
+		VehicleTime:           vehicleTime,
+		IsControlledByUser:    vehicle.IsControlledByUser,
+		Latitude:              vehicle.Latitude,
+		Longitude:             vehicle.Longitude,
+		GpsDirection:          vehicle.GpsDirection,
+		GpsSatelliteCount:     vehicle.GpsSatelliteCount,
+		GpsHorizontalAccuracy: vehicle.GpsHorizontalAccuracy,
+		FrontUltrasonic:       vehicle.FrontUltrasonic,
+		FrontLidar:            vehicle.FrontLidar,
+		RearUltrasonic:        vehicle.RearUltrasonic,
+		Speed:                 vehicle.Speed,
+		SpeedFrontLeft:        vehicle.SpeedFrontLeft,
+		SpeedFrontRight:       vehicle.SpeedFrontRight,
+		SpeedRearLeft:         vehicle.SpeedRearLeft,
+		SpeedRearRight:        vehicle.SpeedRearRight,
+		Voltage0:              vehicle.Voltage0,
+		Voltage1:              vehicle.Voltage1,
+		Voltage2:              vehicle.Voltage2,
+	})
+}
+
+// Add appends the row to the buffer, replacing the oldest row if the buffer is full.
+func (writer *TelemetryWriter) Add(row Telemetry) {
+	writer.Lock()
+	defer writer.Unlock()
+
+	if writer.Count == len(writer.Buffer) && len(writer.Buffer) < writer.Options.BufferSize {
+		buffer := make([]Telemetry, min(2*len(writer.Buffer), writer.Options.BufferSize))
+		writer.copyOldest(buffer)
+		writer.Buffer = buffer
+		writer.Head = 0
+	}
+	if writer.Count == len(writer.Buffer) {
+		writer.Buffer[writer.Head] = row
+		writer.Head = (writer.Head + 1) % len(writer.Buffer)
+		writer.Dropped++
+		metrics.TelemetryDropped()
+		return
+	}
+	writer.Buffer[(writer.Head+writer.Count)%len(writer.Buffer)] = row
+	writer.Count++
+	metrics.SetTelemetryBuffered(writer.Count)
+}
+
+// copyOldest copies the oldest buffered rows in order into rows and returns their number.
+func (writer *TelemetryWriter) copyOldest(rows []Telemetry) int {
+	n := min(len(rows), writer.Count)
+	copied := copy(rows[:n], writer.Buffer[writer.Head:])
+	copy(rows[copied:n], writer.Buffer)
+	return n
+}
+
+func (writer *TelemetryWriter) GetBufferedCount() int {
+	writer.Lock()
+	defer writer.Unlock()
+	return writer.Count
+}
+
+// Run inserts the buffered rows every flush interval until the context is done.
+func (writer *TelemetryWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(writer.Options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Write full batches right away, the rest waits for the next tick
+			for {
+				written, err := writer.writeBatch(ctx)
+				if err != nil || written < writer.Options.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Flush inserts all buffered rows, it stops when the context is done or the database is unavailable.
+func (writer *TelemetryWriter) Flush(ctx context.Context) error {
+	for {
+		written, err := writer.writeBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return nil
+		}
+	}
+}
+
+// writeBatch inserts the oldest rows of the buffer. Rows stay buffered if the insert fails.
+func (writer *TelemetryWriter) writeBatch(ctx context.Context) (int, error) {
+	writer.writing.Lock()
+	defer writer.writing.Unlock()
+
+	writer.Lock()
+	batch := make([]Telemetry, min(writer.Count, writer.Options.BatchSize))
+	writer.copyOldest(batch)
+	droppedBefore := writer.Dropped
+	writer.Unlock()
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	db := GetDB()
+	if db == nil {
+		return 0, errors.New("not connected to the database")
+	}
+	// The insert of a batch may have succeeded although it returned an error, rows stored already are skipped
+	err := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&batch).Error
+	if err != nil {
+		sentry.CaptureException(err)
+		metrics.TelemetryWriteFailed()
+		fmt.Printf("Failed to write %v telemetry rows: %v\n", len(batch), err)
+		return 0, err
+	}
+	metrics.TelemetryWritten(len(batch))
+
+	// Rows dropped while writing were the oldest ones, i.e. the head of the batch
+	writer.Lock()
+	remove := max(len(batch)-int(writer.Dropped-droppedBefore), 0)
+	writer.Head = (writer.Head + remove) % len(writer.Buffer)
+	writer.Count -= remove
+	metrics.SetTelemetryBuffered(writer.Count)
+	writer.Unlock()
+	return len(batch), nil
+}
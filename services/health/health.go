@@ -0,0 +1,133 @@
+package health
+
+import (
+	communication "car-integration/services/communication"
+	database "car-integration/services/database"
+	redis "car-integration/services/redis"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusOk          = "ok"
+	StatusFailed      = "failed"
+	StatusUnavailable = "unavailable" // Optional dependency is not available, the module keeps working without it
+)
+
+// checkTimeout limits every check of the external services.
+const checkTimeout = 2 * time.Second
+
+// Checker reports the state of the listeners and dependencies of the Integration Module.
+type Checker struct {
+	DataModel     *communication.DataModel
+	Managers      []*communication.ConnectionsManager
+	RedisRequired bool // Redis stores the network statistics or snapshots, otherwise it is reported but never fails readiness
+}
+
+type Report struct {
+	Status                      string           `json:"status"`
+	Listeners                   []ListenerReport `json:"listeners"`
+	Redis                       ComponentReport  `json:"redis"`
+	Database                    ComponentReport  `json:"database"`
+	LastVehicleUpdateAgeSeconds *float64         `json:"last_vehicle_update_age_seconds"` // null if no vehicle sent an update yet
+}
+
+type ListenerReport struct {
+	Port           int    `json:"port"`
+	ConnectionType string `json:"connection_type"`
+	State          string `json:"state"`
+	Error          string `json:"error,omitempty"`
+}
+
+type ComponentReport struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func NewChecker(dataModel *communication.DataModel, managers []*communication.ConnectionsManager, redisRequired bool) *Checker {
+	return &Checker{
+		DataModel:     dataModel,
+		Managers:      managers,
+		RedisRequired: redisRequired,
+	}
+}
+
+// Handler serves /healthz (liveness, fails if a listener failed) and /readyz (readiness, fails also while
+// a listener is starting or Redis is unreachable while it is required). TimescaleDB is optional, it is reported but never fails them.
+func (checker *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(writer http.ResponseWriter, request *http.Request) {
+		checker.serve(writer, request, false)
+	})
+	mux.HandleFunc("GET /readyz", func(writer http.ResponseWriter, request *http.Request) {
+		checker.serve(writer, request, true)
+	})
+	return mux
+}
+
+func (checker *Checker) Check(ctx context.Context, readiness bool) Report {
+	report := Report{
+		Status:    StatusOk,
+		Listeners: []ListenerReport{},
+	}
+
+	for _, manager := range checker.Managers {
+		port, state, err := manager.GetListenerState(true)
+		listener := ListenerReport{
+			Port:           port,
+			ConnectionType: manager.ConnectionType,
+			State:          state,
+		}
+		if err != nil {
+			listener.Error = err.Error()
+		}
+		if state == communication.ListenerFailed || (readiness && state != communication.ListenerListening) {
+			report.Status = StatusFailed
+		}
+		report.Listeners = append(report.Listeners, listener)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report.Redis = ComponentReport{Status: StatusOk}
+	if err := redis.Ping(ctx); err != nil {
+		if !checker.RedisRequired {
+			report.Redis = ComponentReport{Status: StatusUnavailable, Error: err.Error()}
+		} else {
+			report.Redis = ComponentReport{Status: StatusFailed, Error: err.Error()}
+			if readiness {
+				report.Status = StatusFailed
+			}
+		}
+	}
+
+	report.Database = ComponentReport{Status: StatusOk}
+	if err := database.Ping(ctx); err != nil {
+		report.Database = ComponentReport{Status: StatusUnavailable, Error: err.Error()}
+	}
+
+	if lastUpdateAt := checker.DataModel.GetLastVehicleUpdateAt(true); !lastUpdateAt.IsZero() {
+		age := time.Since(lastUpdateAt).Seconds()
+		report.LastVehicleUpdateAgeSeconds = &age
+	}
+	return report
+}
+
+func (checker *Checker) serve(writer http.ResponseWriter, request *http.Request, readiness bool) {
+	report := checker.Check(request.Context(), readiness)
+
+	status := http.StatusOK
+	if report.Status != StatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(report)
+	if err != nil {
+		fmt.Printf("Failed to write health response: %v\n", err)
+	}
+}
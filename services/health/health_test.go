@@ -0,0 +1,94 @@
+package health
+
+import (
+	"car-integration/models"
+	communication "car-integration/services/communication"
+	redis "car-integration/services/redis"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestCheck(t *testing.T) {
+	server := miniredis.RunT(t)
+	defer func() { redis.DB = nil }()
+
+	tests := []struct {
+		name          string
+		listener      string
+		redisAddress  string // Empty for Redis not initialized
+		redisRequired bool
+		wantRedis     string
+		wantLiveness  string
+		wantReadiness string
+	}{
+		{"all ok", communication.ListenerListening, server.Addr(), true, StatusOk, StatusOk, StatusOk},
+		{"listener starting", communication.ListenerStarting, server.Addr(), true, StatusOk, StatusOk, StatusFailed},
+		{"listener failed", communication.ListenerFailed, server.Addr(), true, StatusOk, StatusFailed, StatusFailed},
+		{"required redis unreachable", communication.ListenerListening, "127.0.0.1:1", true, StatusFailed, StatusOk, StatusFailed},
+		{"optional redis unreachable", communication.ListenerListening, "127.0.0.1:1", false, StatusUnavailable, StatusOk, StatusOk},
+		{"optional redis not initialized", communication.ListenerListening, "", false, StatusUnavailable, StatusOk, StatusOk},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redis.DB = nil
+			if test.redisAddress != "" {
+				redis.Init(test.redisAddress, "", 0)
+			}
+			dataModel := communication.NewDataModel(&models.Area{}, 5)
+			manager := communication.NewConnectionsManager(dataModel, "processor", 0, nil)
+			manager.State = test.listener
+			checker := NewChecker(dataModel, []*communication.ConnectionsManager{manager}, test.redisRequired)
+
+			liveness := checker.Check(context.Background(), false)
+			readiness := checker.Check(context.Background(), true)
+			if liveness.Status != test.wantLiveness || readiness.Status != test.wantReadiness {
+				t.Errorf("liveness %v and readiness %v, want %v and %v", liveness.Status, readiness.Status, test.wantLiveness, test.wantReadiness)
+			}
+			if readiness.Redis.Status != test.wantRedis {
+				t.Errorf("redis %+v, want %v", readiness.Redis, test.wantRedis)
+			}
+			// TimescaleDB is not connected in tests, it never fails the checks
+			if readiness.Database.Status != StatusUnavailable {
+				t.Errorf("database %+v, want %v", readiness.Database, StatusUnavailable)
+			}
+			if readiness.LastVehicleUpdateAgeSeconds != nil {
+				t.Errorf("last vehicle update age %v without any update", *readiness.LastVehicleUpdateAgeSeconds)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	defer func() { redis.DB = nil }()
+	redis.DB = nil
+
+	dataModel := communication.NewDataModel(&models.Area{}, 5)
+	manager := communication.NewConnectionsManager(dataModel, "processor", 0, nil)
+	handler := NewChecker(dataModel, []*communication.ConnectionsManager{manager}, false).Handler()
+
+	tests := []struct {
+		listener string
+		path     string
+		want     int
+	}{
+		{communication.ListenerStarting, "/healthz", http.StatusOK},
+		{communication.ListenerStarting, "/readyz", http.StatusServiceUnavailable},
+		{communication.ListenerListening, "/readyz", http.StatusOK},
+		{communication.ListenerFailed, "/healthz", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		manager.State = test.listener
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if recorder.Code != test.want {
+			t.Errorf("%v with listener %v answered %v, want %v", test.path, test.listener, recorder.Code, test.want)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%v answered %v, want application/json", test.path, contentType)
+		}
+	}
+}
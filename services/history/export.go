@@ -0,0 +1,169 @@
+package history
+
+import (
+	database "car-integration/services/database"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Export formats
+const (
+	FormatJSON    = "json"
+	FormatCSV     = "csv"
+	FormatGeoJSON = "geojson"
+)
+
+// ContentTypes of the export formats
+var ContentTypes = map[string]string{
+	FormatJSON:    "application/json",
+	FormatCSV:     "text/csv",
+	FormatGeoJSON: "application/geo+json",
+}
+
+// csvHeader are the columns of the CSV export, in the order of csvRecord.
+var csvHeader = []string{
+	"time", "vin", "vehicle_time", "is_controlled_by_user", "latitude", "longitude", "gps_direction",
+	"gps_satellite_count", "gps_horizontal_accuracy", "front_ultrasonic", "front_lidar", "rear_ultrasonic",
+	"speed", "speed_front_left", "speed_front_right", "speed_rear_left", "speed_rear_right",
+	"voltage0", "voltage1", "voltage2",
+}
+
+// Row is the JSON form of a telemetry row.
+type Row struct {
+	Time        string `json:"time"`                   // Time of receipt, in the clock of the module
+	VehicleTime string `json:"vehicle_time,omitempty"` // Timestamp sent by the vehicle, in its own clock
+	api.UpdateVehicleVehicle
+}
+
+// WriteTrack writes the track of one vehicle, as a GeoJSON Feature in the geojson format. Its geometry is a LineString,
+// a Point for a single position and null for an empty track, as a LineString needs at least two positions.
+func WriteTrack(writer io.Writer, format string, rows []database.Telemetry) error {
+	if format != FormatGeoJSON {
+		return write(writer, format, rows)
+	}
+
+	coordinates := make([][2]float32, len(rows))
+	times := make([]string, len(rows))
+	for i, row := range rows {
+		coordinates[i] = [2]float32{row.Longitude, row.Latitude}
+		times[i] = formatTime(row.Time)
+	}
+	properties := map[string]interface{}{"times": times}
+	var geometry interface{}
+	switch len(rows) {
+	case 0:
+	case 1:
+		geometry = map[string]interface{}{"type": "Point", "coordinates": coordinates[0]}
+	default:
+		geometry = map[string]interface{}{"type": "LineString", "coordinates": coordinates}
+	}
+	if len(rows) > 0 {
+		properties["vin"] = rows[0].Vin
+		properties["from"] = times[0]
+		properties["to"] = times[len(times)-1]
+	}
+	return json.NewEncoder(writer).Encode(map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   geometry,
+		"properties": properties,
+	})
+}
+
+// WriteVehicles writes the rows of several vehicles, as a GeoJSON FeatureCollection of Points in the geojson format.
+func WriteVehicles(writer io.Writer, format string, rows []database.Telemetry) error {
+	if format != FormatGeoJSON {
+		return write(writer, format, rows)
+	}
+
+	features := make([]interface{}, len(rows))
+	for i, row := range rows {
+		features[i] = map[string]interface{}{
+			"type":       "Feature",
+			"geometry":   map[string]interface{}{"type": "Point", "coordinates": [2]float32{row.Longitude, row.Latitude}},
+			"properties": toRow(row),
+		}
+	}
+	return json.NewEncoder(writer).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+func write(writer io.Writer, format string, rows []database.Telemetry) error {
+	switch format {
+	case FormatJSON:
+		jsonRows := make([]Row, len(rows))
+		for i, row := range rows {
+			jsonRows[i] = toRow(row)
+		}
+		return json.NewEncoder(writer).Encode(jsonRows)
+
+	case FormatCSV:
+		csvWriter := csv.NewWriter(writer)
+		_ = csvWriter.Write(csvHeader)
+		for _, row := range rows {
+			_ = csvWriter.Write(csvRecord(row))
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return fmt.Errorf("unknown format %q, expected %v, %v or %v", format, FormatJSON, FormatCSV, FormatGeoJSON)
+}
+
+func toRow(row database.Telemetry) Row {
+	return Row{
+		Time:        formatTime(row.Time),
+		VehicleTime: formatOptionalTime(row.VehicleTime),
+		UpdateVehicleVehicle: api.UpdateVehicleVehicle{
+			Vin:                   row.Vin,
+			IsControlledByUser:    row.IsControlledByUser,
+			Latitude:              row.Latitude,
+			Longitude:             row.Longitude,
+			GpsDirection:          row.GpsDirection,
+			GpsSatelliteCount:     row.GpsSatelliteCount,
+			GpsHorizontalAccuracy: row.GpsHorizontalAccuracy,
+			FrontUltrasonic:       row.FrontUltrasonic,
+			FrontLidar:            row.FrontLidar,
+			RearUltrasonic:        row.RearUltrasonic,
+			Speed:                 row.Speed,
+			SpeedFrontLeft:        row.SpeedFrontLeft,
+			SpeedFrontRight:       row.SpeedFrontRight,
+			SpeedRearLeft:         row.SpeedRearLeft,
+			SpeedRearRight:        row.SpeedRearRight,
+			Voltage0:              row.Voltage0,
+			Voltage1:              row.Voltage1,
+			Voltage2:              row.Voltage2,
+		},
+	}
+}
+
+func csvRecord(row database.Telemetry) []string {
+	values := []float32{
+		row.Latitude, row.Longitude, row.GpsDirection, row.GpsSatelliteCount, row.GpsHorizontalAccuracy,
+		row.FrontUltrasonic, row.FrontLidar, row.RearUltrasonic,
+		row.Speed, row.SpeedFrontLeft, row.SpeedFrontRight, row.SpeedRearLeft, row.SpeedRearRight,
+		row.Voltage0, row.Voltage1, row.Voltage2,
+	}
+	record := []string{formatTime(row.Time), row.Vin, formatOptionalTime(row.VehicleTime), strconv.FormatBool(row.IsControlledByUser)}
+	for _, value := range values {
+		record = append(record, strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	return record
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(api.TimestampFormat)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
@@ -0,0 +1,169 @@
+package history
+
+import (
+	database "car-integration/services/database"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// telemetry returns rows of the vehicle a second apart at the positions, given as longitude and latitude pairs.
+func telemetry(vin string, positions ...[2]float32) []database.Telemetry {
+	start := time.Date(2024, 5, 4, 10, 0, 0, 0, time.UTC)
+	rows := make([]database.Telemetry, len(positions))
+	for i, position := range positions {
+		rows[i] = database.Telemetry{
+			Time:      start.Add(time.Duration(i) * time.Second),
+			Vin:       vin,
+			Longitude: position[0],
+			Latitude:  position[1],
+			Speed:     1.5,
+		}
+	}
+	return rows
+}
+
+func TestWriteTrackGeoJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		rows            []database.Telemetry
+		wantGeometry    string
+		wantCoordinates string
+		wantFrom        string
+		wantTo          string
+	}{
+		{"line", telemetry("VIN1", [2]float32{17, 48}, [2]float32{17.5, 48.5}), "LineString", "[[17,48],[17.5,48.5]]",
+			"2024-05-04T10:00:00Z", "2024-05-04T10:00:01Z"},
+		{"single position", telemetry("VIN1", [2]float32{17, 48}), "Point", "[17,48]",
+			"2024-05-04T10:00:00Z", "2024-05-04T10:00:00Z"},
+		{"empty", nil, "", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output strings.Builder
+			if err := WriteTrack(&output, FormatGeoJSON, test.rows); err != nil {
+				t.Fatal(err)
+			}
+			var feature struct {
+				Type     string
+				Geometry *struct {
+					Type        string
+					Coordinates json.RawMessage
+				}
+				Properties struct {
+					Vin   string
+					From  string
+					To    string
+					Times []string
+				}
+			}
+			if err := json.Unmarshal([]byte(output.String()), &feature); err != nil {
+				t.Fatal(err)
+			}
+			if feature.Type != "Feature" || len(feature.Properties.Times) != len(test.rows) {
+				t.Errorf("%v with %v times, want Feature with %v", feature.Type, len(feature.Properties.Times), len(test.rows))
+			}
+			if test.wantGeometry == "" {
+				if feature.Geometry != nil {
+					t.Errorf("geometry %+v, want null", feature.Geometry)
+				}
+				return
+			}
+			if feature.Geometry == nil || feature.Geometry.Type != test.wantGeometry || string(feature.Geometry.Coordinates) != test.wantCoordinates {
+				t.Fatalf("geometry %+v, want %v %v", feature.Geometry, test.wantGeometry, test.wantCoordinates)
+			}
+			if feature.Properties.Vin != "VIN1" || feature.Properties.From != test.wantFrom || feature.Properties.To != test.wantTo {
+				t.Errorf("properties %+v, want VIN1 from %v to %v", feature.Properties, test.wantFrom, test.wantTo)
+			}
+		})
+	}
+}
+
+func TestWriteVehiclesGeoJSON(t *testing.T) {
+	rows := append(telemetry("VIN1", [2]float32{17, 48}), telemetry("VIN2", [2]float32{-179.5, 0})...)
+	var output strings.Builder
+	if err := WriteVehicles(&output, FormatGeoJSON, rows); err != nil {
+		t.Fatal(err)
+	}
+	var collection struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [2]float32
+			}
+			Properties Row
+		}
+	}
+	if err := json.Unmarshal([]byte(output.String()), &collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 2 {
+		t.Fatalf("%v with %v features, want FeatureCollection with 2", collection.Type, len(collection.Features))
+	}
+	for i, feature := range collection.Features {
+		position := [2]float32{rows[i].Longitude, rows[i].Latitude}
+		if feature.Geometry.Type != "Point" || feature.Geometry.Coordinates != position || feature.Properties.Vin != rows[i].Vin {
+			t.Errorf("feature %+v, want Point %v of %v", feature, position, rows[i].Vin)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	vehicleTime := time.Date(2024, 5, 4, 9, 59, 59, 500000000, time.UTC)
+	rows := telemetry("VIN1", [2]float32{17.25, 48.125})
+	rows[0].VehicleTime = &vehicleTime
+	rows[0].IsControlledByUser = true
+
+	t.Run("json", func(t *testing.T) {
+		var output strings.Builder
+		if err := WriteTrack(&output, FormatJSON, rows); err != nil {
+			t.Fatal(err)
+		}
+		var got []Row
+		if err := json.Unmarshal([]byte(output.String()), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Time != "2024-05-04T10:00:00Z" || got[0].VehicleTime != "2024-05-04T09:59:59.5Z" ||
+			got[0].Vin != "VIN1" || got[0].Longitude != 17.25 || got[0].Latitude != 48.125 || !got[0].IsControlledByUser {
+			t.Errorf("rows %+v, want the telemetry row", got)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var output strings.Builder
+		if err := WriteTrack(&output, FormatCSV, rows); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(strings.NewReader(output.String())).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+			t.Fatalf("records %v, want the header and one row", records)
+		}
+		want := "2024-05-04T10:00:00Z,VIN1,2024-05-04T09:59:59.5Z,true,48.125,17.25,0,0,0,0,0,0,1.5,0,0,0,0,0,0,0"
+		if got := strings.Join(records[1], ","); got != want {
+			t.Errorf("row %v, want %v", got, want)
+		}
+	})
+
+	t.Run("csv without rows", func(t *testing.T) {
+		var output strings.Builder
+		if err := WriteVehicles(&output, FormatCSV, nil); err != nil {
+			t.Fatal(err)
+		}
+		if output.String() != strings.Join(csvHeader, ",")+"\n" {
+			t.Errorf("output %q, want only the header", output.String())
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		var output strings.Builder
+		if err := WriteTrack(&output, "xml", rows); err == nil {
+			t.Error("WriteTrack() of an unknown format succeeded")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package history
+
+import (
+	"car-integration/models"
+	database "car-integration/services/database"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+	"gorm.io/gorm"
+)
+
+// MaxRows limits the rows returned by one query, so a long range without downsampling cannot exhaust the memory.
+const MaxRows = 100000
+
+// ErrInvalidQuery is wrapped by the errors of queries with invalid parameters.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// averagedColumns are averaged within a bucket of a downsampled track. The position is not averaged, an average
+// of longitudes on both sides of the antimeridian would lie on the opposite side of the Earth.
+var averagedColumns = []string{
+	"gps_satellite_count", "gps_horizontal_accuracy",
+	"front_ultrasonic", "front_lidar", "rear_ultrasonic",
+	"speed", "speed_front_left", "speed_front_right", "speed_rear_left", "speed_rear_right",
+	"voltage0", "voltage1", "voltage2",
+}
+
+// TrackQuery selects the telemetry of one vehicle.
+type TrackQuery struct {
+	Vin      string
+	From     time.Time
+	To       time.Time     // Exclusive
+	Interval time.Duration // Bucket of the downsampling, 0 for all rows
+	Limit    int           // Maximum number of rows, 0 or above MaxRows for MaxRows
+}
+
+// Track returns the telemetry of the vehicle ordered by time. Downsampled rows carry the start of their bucket,
+// averaged values, the last position and heading and whether the vehicle was controlled by the user at any time of the bucket.
+func Track(ctx context.Context, db *gorm.DB, query TrackQuery) ([]database.Telemetry, error) {
+	if query.Vin == "" {
+		return nil, fmt.Errorf("%w: vin is required", ErrInvalidQuery)
+	}
+	if !query.From.Before(query.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+	limit := query.Limit
+	if limit <= 0 || limit > MaxRows {
+		limit = MaxRows
+	}
+
+	rows := []database.Telemetry{}
+	if query.Interval <= 0 {
+		err := db.WithContext(ctx).
+			Where("vin = ? AND time >= ? AND time < ?", query.Vin, query.From, query.To).
+			Order("time").Limit(limit).Find(&rows).Error
+		return rows, err
+	}
+
+	columns := []string{
+		"time_bucket(make_interval(secs => @interval), time) AS time",
+		"vin",
+		"last(vehicle_time, time) AS vehicle_time",
+		"bool_or(is_controlled_by_user) AS is_controlled_by_user",
+		"last(latitude, time) AS latitude",
+		"last(longitude, time) AS longitude",
+		"last(gps_direction, time) AS gps_direction",
+	}
+	for _, column := range averagedColumns {
+		columns = append(columns, fmt.Sprintf("avg(%v) AS %v", column, column))
+	}
+	sql := "SELECT " + strings.Join(columns, ", ") + `
+		FROM vehicle_telemetry
+		WHERE vin = @vin AND time >= @from AND time < @to
+		GROUP BY 1, vin ORDER BY 1 LIMIT @limit`
+	err := db.WithContext(ctx).Raw(sql, map[string]interface{}{
+		"interval": query.Interval.Seconds(),
+		"vin":      query.Vin,
+		"from":     query.From,
+		"to":       query.To,
+		"limit":    limit,
+	}).Scan(&rows).Error
+	return rows, err
+}
+
+// PresenceQuery selects the vehicles present in the area at an instant.
+type PresenceQuery struct {
+	At     time.Time
+	Window time.Duration // Maximum age of the last update of a vehicle, older vehicles are considered gone
+	Area   *models.Area
+	Zone   string // Name of a zone of the area the vehicles have to be in, empty for the whole area
+}
+
+// VehiclesAt returns the last update of every vehicle within the window before the instant which is inside the area.
+func VehiclesAt(ctx context.Context, db *gorm.DB, query PresenceQuery) ([]database.Telemetry, error) {
+	if query.Window <= 0 {
+		return nil, fmt.Errorf("%w: window must be positive", ErrInvalidQuery)
+	}
+	var candidates []database.Telemetry
+	err := db.WithContext(ctx).Raw(`
+		SELECT DISTINCT ON (vin) * FROM vehicle_telemetry
+		WHERE time <= ? AND time > ?
+		ORDER BY vin, time DESC`, query.At, query.At.Add(-query.Window)).Scan(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []database.Telemetry{}
+	for _, row := range candidates {
+		position := &api.PositionJSON{Lat: row.Latitude, Lon: row.Longitude}
+		if query.Area != nil && !query.Area.Contains(position) {
+			continue
+		}
+		if query.Zone != "" && !inZone(query.Area, position, query.Zone) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func inZone(area *models.Area, position *api.PositionJSON, name string) bool {
+	if area == nil {
+		return false
+	}
+	for _, zone := range area.ZonesAt(position) {
+		if zone.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package history
+
+import (
+	"car-integration/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultWindow is the maximum age of the last update of a vehicle present at an instant.
+const DefaultWindow = 10 * time.Second
+
+// Server is the HTTP API for analysts reading the stored telemetry, see Track and VehiclesAt.
+type Server struct {
+	GetDB   func() *gorm.DB     // Returns nil while the database is not connected
+	GetArea func() *models.Area // Area the present vehicles are looked up in
+}
+
+func NewServer(getDB func() *gorm.DB, getArea func() *models.Area) *Server {
+	return &Server{
+		GetDB:   getDB,
+		GetArea: getArea,
+	}
+}
+
+// Handler returns the API routes, all of them under /history/.
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /history/vehicles/{vin}/track", server.track)
+	mux.HandleFunc("GET /history/vehicles", server.vehiclesAt)
+	return mux
+}
+
+// track returns the track of the vehicle between from and to (default now), downsampled to interval if given.
+func (server *Server) track(writer http.ResponseWriter, request *http.Request) {
+	parameters := request.URL.Query()
+	format, err := parseFormat(parameters.Get("format"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := parseTrackQuery(request.PathValue("vin"), parameters, time.Now())
+	if err != nil {
+		http.Error(writer, "invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := server.GetDB()
+	if db == nil {
+		http.Error(writer, "not connected to the database", http.StatusServiceUnavailable)
+		return
+	}
+	rows, err := Track(request.Context(), db, query)
+	if err != nil {
+		writeQueryError(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", ContentTypes[format])
+	err = WriteTrack(writer, format, rows)
+	if err != nil {
+		fmt.Printf("Failed to write history API response: %v\n", err)
+	}
+}
+
+// vehiclesAt returns the vehicles in the area, or its zone, at the instant (default now).
+func (server *Server) vehiclesAt(writer http.ResponseWriter, request *http.Request) {
+	parameters := request.URL.Query()
+	format, err := parseFormat(parameters.Get("format"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := parsePresenceQuery(parameters, server.GetArea(), time.Now())
+	if err != nil {
+		http.Error(writer, "invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := server.GetDB()
+	if db == nil {
+		http.Error(writer, "not connected to the database", http.StatusServiceUnavailable)
+		return
+	}
+	rows, err := VehiclesAt(request.Context(), db, query)
+	if err != nil {
+		writeQueryError(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Type", ContentTypes[format])
+	err = WriteVehicles(writer, format, rows)
+	if err != nil {
+		fmt.Printf("Failed to write history API response: %v\n", err)
+	}
+}
+
+// parseTrackQuery reads the track parameters, to defaults to now and from to an hour before to.
+func parseTrackQuery(vin string, parameters url.Values, now time.Time) (TrackQuery, error) {
+	query := TrackQuery{Vin: vin}
+	var err error
+	query.To, err = ParseTime(parameters.Get("to"), now)
+	if err == nil {
+		query.From, err = ParseTime(parameters.Get("from"), query.To.Add(-time.Hour))
+	}
+	if err == nil && parameters.Get("interval") != "" {
+		query.Interval, err = time.ParseDuration(parameters.Get("interval"))
+	}
+	if err == nil && parameters.Get("limit") != "" {
+		query.Limit, err = strconv.Atoi(parameters.Get("limit"))
+	}
+	return query, err
+}
+
+// parsePresenceQuery reads the presence parameters, at defaults to now and window to DefaultWindow.
+func parsePresenceQuery(parameters url.Values, area *models.Area, now time.Time) (PresenceQuery, error) {
+	query := PresenceQuery{
+		Window: DefaultWindow,
+		Area:   area,
+		Zone:   parameters.Get("zone"),
+	}
+	var err error
+	query.At, err = ParseTime(parameters.Get("at"), now)
+	if err == nil && parameters.Get("window") != "" {
+		query.Window, err = time.ParseDuration(parameters.Get("window"))
+	}
+	return query, err
+}
+
+func writeQueryError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidQuery) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(writer, "query failed: "+err.Error(), http.StatusInternalServerError)
+}
+
+// ParseTime parses an RFC 3339 time, an empty value returns the default.
+func ParseTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseFormat validates the export format, json by default.
+func parseFormat(format string) (string, error) {
+	if format == "" {
+		return FormatJSON, nil
+	}
+	if _, ok := ContentTypes[format]; !ok {
+		return "", fmt.Errorf("unknown format %q, expected %v, %v or %v", format, FormatJSON, FormatCSV, FormatGeoJSON)
+	}
+	return format, nil
+}
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"car-integration/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var now = time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+
+func TestParseTrackQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    TrackQuery
+		wantErr bool
+	}{
+		{"defaults", "", TrackQuery{Vin: "VIN1", From: now.Add(-time.Hour), To: now}, false},
+		{"range", "from=2024-05-04T10:00:00Z&to=2024-05-04T12:30:00.5%2B02:00", TrackQuery{Vin: "VIN1",
+			From: time.Date(2024, 5, 4, 10, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, 5, 4, 10, 30, 0, 500000000, time.UTC)}, false},
+		{"from defaults to an hour before to", "to=2024-05-04T10:00:00Z", TrackQuery{Vin: "VIN1",
+			From: time.Date(2024, 5, 4, 9, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, 5, 4, 10, 0, 0, 0, time.UTC)}, false},
+		{"interval and limit", "interval=5s&limit=10", TrackQuery{Vin: "VIN1", From: now.Add(-time.Hour), To: now,
+			Interval: 5 * time.Second, Limit: 10}, false},
+		{"invalid from", "from=yesterday", TrackQuery{}, true},
+		{"invalid to", "to=2024-05-04", TrackQuery{}, true},
+		{"invalid interval", "interval=5", TrackQuery{}, true},
+		{"invalid limit", "limit=ten", TrackQuery{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, err := parseTrackQuery("VIN1", parameters, now)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parseTrackQuery(%q) = %+v, want error", test.query, query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query.Vin != test.want.Vin || !query.From.Equal(test.want.From) || !query.To.Equal(test.want.To) ||
+				query.Interval != test.want.Interval || query.Limit != test.want.Limit {
+				t.Errorf("parseTrackQuery(%q) = %+v, want %+v", test.query, query, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePresenceQuery(t *testing.T) {
+	area := &models.Area{}
+	tests := []struct {
+		name    string
+		query   string
+		want    PresenceQuery
+		wantErr bool
+	}{
+		{"defaults", "", PresenceQuery{At: now, Window: DefaultWindow}, false},
+		{"instant, window and zone", "at=2024-05-04T10:12:00Z&window=30s&zone=pit-lane", PresenceQuery{
+			At: time.Date(2024, 5, 4, 10, 12, 0, 0, time.UTC), Window: 30 * time.Second, Zone: "pit-lane"}, false},
+		{"invalid at", "at=noon", PresenceQuery{}, true},
+		{"invalid window", "window=10", PresenceQuery{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, err := parsePresenceQuery(parameters, area, now)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parsePresenceQuery(%q) = %+v, want error", test.query, query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !query.At.Equal(test.want.At) || query.Window != test.want.Window || query.Zone != test.want.Zone || query.Area != area {
+				t.Errorf("parsePresenceQuery(%q) = %+v, want %+v in the area", test.query, query, test.want)
+			}
+		})
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	server := NewServer(func() *gorm.DB { return nil }, func() *models.Area { return &models.Area{} })
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"track without database", "/history/vehicles/VIN1/track", http.StatusServiceUnavailable},
+		{"vehicles without database", "/history/vehicles?format=geojson", http.StatusServiceUnavailable},
+		{"unknown format", "/history/vehicles/VIN1/track?format=xml", http.StatusBadRequest},
+		{"invalid track parameter", "/history/vehicles/VIN1/track?limit=ten", http.StatusBadRequest},
+		{"invalid vehicles parameter", "/history/vehicles?window=10", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if recorder.Code != test.wantStatus {
+				t.Errorf("GET %v = %v, want %v", test.path, recorder.Code, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	from := now.Add(-time.Hour)
+	tests := []struct {
+		name  string
+		query func() error
+	}{
+		{"track without vin", func() error {
+			_, err := Track(context.Background(), nil, TrackQuery{From: from, To: now})
+			return err
+		}},
+		{"track from after to", func() error {
+			_, err := Track(context.Background(), nil, TrackQuery{Vin: "VIN1", From: now, To: from})
+			return err
+		}},
+		{"presence without window", func() error {
+			_, err := VehiclesAt(context.Background(), nil, PresenceQuery{At: now})
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.query(); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("error %v, want %v", err, ErrInvalidQuery)
+			}
+		})
+	}
+}
@@ -6,13 +6,14 @@ import (
 	"github.com/getsentry/sentry-go"
 )
 
-func Init() {
+// Init configures Sentry, events are discarded if the DSN is empty.
+func Init(dsn string, tracesSampleRate float64) {
 	err := sentry.Init(sentry.ClientOptions{
-		Dsn: "https://d735b9fec79664425c2a08556e15406a@o4508080703864832.ingest.de.sentry.io/4508080710025296",
+		Dsn: dsn,
 		// Set TracesSampleRate to 1.0 to capture 100%
 		// of transactions for tracing.
 		// We recommend adjusting this value in production,
-		TracesSampleRate: 1.0,
+		TracesSampleRate: tracesSampleRate,
 	})
 	if err != nil {
 		log.Fatalf("sentry.Init: %s", err)
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	datagramsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "car_integration_datagrams_received_total",
+		Help: "Datagrams received by port and type.",
+	}, []string{"port", "type"})
+
+	datagramsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "car_integration_datagrams_sent_total",
+		Help: "Datagrams sent by port and type.",
+	}, []string{"port", "type"})
+
+	parseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "car_integration_parse_failures_total",
+		Help: "Received datagrams which could not be parsed, by port.",
+	}, []string{"port"})
+
+	activeConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "car_integration_active_connections",
+		Help: "Connections of each ConnectionsManager, by port and connection type.",
+	}, []string{"port", "connection_type"})
+
+	activeSubscriptions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "car_integration_active_subscriptions",
+		Help: "Running subscriptions by content and topic.",
+	}, []string{"content", "topic"})
+
+	vehicleLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "car_integration_vehicle_latency_seconds",
+		Help: "Average latency of vehicle telemetry, corrected by the vehicle clock offset.",
+	}, []string{"vin"})
+
+	vehicleJitter = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "car_integration_vehicle_jitter_seconds",
+		Help: "Jitter of vehicle telemetry.",
+	}, []string{"vin"})
+
+	vehicleLossRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "car_integration_vehicle_loss_rate",
+		Help: "Ratio of vehicle telemetry datagrams lost.",
+	}, []string{"vin"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "car_integration_redis_errors_total",
+		Help: "Failed Redis operations by operation.",
+	}, []string{"operation"})
+
+	decisionForwardingLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "car_integration_decision_forwarding_latency_seconds",
+		Help:    "Time from receiving a decision update from a processor until it is sent to the vehicle.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	vehiclesRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "car_integration_rejected_vehicle_updates_total",
+		Help: "Vehicle updates dropped because the VIN is not allowed.",
+	})
+
+	telemetryBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "car_integration_telemetry_buffered",
+		Help: "Vehicle telemetry rows waiting to be written to TimescaleDB.",
+	})
+
+	telemetryWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "car_integration_telemetry_written_total",
+		Help: "Vehicle telemetry rows written to TimescaleDB.",
+	})
+
+	telemetryDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "car_integration_telemetry_dropped_total",
+		Help: "Vehicle telemetry rows dropped because the buffer was full.",
+	})
+
+	telemetryWriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "car_integration_telemetry_write_failures_total",
+		Help: "Failed inserts of vehicle telemetry batches.",
+	})
+
+	deliveryExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "car_integration_reliable_delivery_expired_total",
+		Help: "Datagrams given up by the reliable delivery without acknowledgement.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterGaugeFunc exposes a gauge whose value is read by the function on every scrape.
+func RegisterGaugeFunc(name string, help string, function func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, function)
+}
+
+func DatagramReceived(port int, datagramType string) {
+	datagramsReceived.WithLabelValues(strconv.Itoa(port), datagramType).Inc()
+}
+
+func DatagramSent(port int, datagramType string) {
+	datagramsSent.WithLabelValues(strconv.Itoa(port), datagramType).Inc()
+}
+
+func ParseFailure(port int) {
+	parseFailures.WithLabelValues(strconv.Itoa(port)).Inc()
+}
+
+func SetActiveConnections(port int, connectionType string, count int) {
+	activeConnections.WithLabelValues(strconv.Itoa(port), connectionType).Set(float64(count))
+}
+
+func SubscriptionStarted(content string, topic string) {
+	activeSubscriptions.WithLabelValues(content, topic).Inc()
+}
+
+func SubscriptionEnded(content string, topic string) {
+	activeSubscriptions.WithLabelValues(content, topic).Dec()
+}
+
+func SetVehicleNetworkStats(vin string, latency time.Duration, jitter time.Duration, lossRate float64) {
+	vehicleLatency.WithLabelValues(vin).Set(latency.Seconds())
+	vehicleJitter.WithLabelValues(vin).Set(jitter.Seconds())
+	vehicleLossRate.WithLabelValues(vin).Set(lossRate)
+}
+
+// DeleteVehicle removes the per-VIN series of a disconnected vehicle.
+func DeleteVehicle(vin string) {
+	vehicleLatency.DeleteLabelValues(vin)
+	vehicleJitter.DeleteLabelValues(vin)
+	vehicleLossRate.DeleteLabelValues(vin)
+}
+
+func RedisError(operation string) {
+	redisErrors.WithLabelValues(operation).Inc()
+}
+
+func DecisionForwarded(latency time.Duration) {
+	decisionForwardingLatency.Observe(latency.Seconds())
+}
+
+func DeliveryExpired() {
+	deliveryExpired.Inc()
+}
+
+func VehicleRejected() {
+	vehiclesRejected.Inc()
+}
+
+func SetTelemetryBuffered(count int) {
+	telemetryBuffered.Set(float64(count))
+}
+
+func TelemetryWritten(count int) {
+	telemetryWritten.Add(float64(count))
+}
+
+func TelemetryDropped() {
+	telemetryDropped.Inc()
+}
+
+func TelemetryWriteFailed() {
+	telemetryWriteFailures.Inc()
+}
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/getsentry/sentry-go"
@@ -10,11 +11,11 @@ import (
 
 var DB *r.Client
 
-func Init() {
+func Init(address string, password string, db int) {
 	DB = r.NewClient(&r.Options{
-		Addr:     "redis:6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
+		Addr:     address,
+		Password: password,
+		DB:       db,
 	})
 }
 
@@ -33,3 +34,11 @@ func HealthCheck() bool {
 	fmt.Println("Redis health check passed: ", pong)
 	return true
 }
+
+// Ping checks the connectivity to Redis without logging, used by the health endpoints.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("redis is not initialized")
+	}
+	return DB.Ping(ctx).Err()
+}
@@ -3,56 +3,179 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	metrics "car-integration/services/metrics"
 	statistics "car-integration/services/statistics"
 
 	"github.com/getsentry/sentry-go"
+	r "github.com/redis/go-redis/v9"
 )
 
-func GetNetworkStats(key string) *statistics.NetworkStats {
-	ctx := context.Background()
-	db := GetDB() // Get the Redis client
+// StatsStore is a statistics.StatsStore in Redis. Saved stats are coalesced per VIN in memory
+// and written in one pipeline every flush interval, so the telemetry loop never waits for Redis.
+type StatsStore struct {
+	sync.Mutex
+	Client    *r.Client
+	Namespace string        // Prefix of the keys, followed by the VIN
+	TTL       time.Duration // Expiry of the keys, 0 for no expiry
+	Pending   map[string][]byte
+}
+
+func NewStatsStore(client *r.Client, namespace string, ttl time.Duration) *StatsStore {
+	return &StatsStore{
+		Client:    client,
+		Namespace: namespace,
+		TTL:       ttl,
+		Pending:   make(map[string][]byte),
+	}
+}
 
-	// Get the serialized data from Redis
-	serialized, err := db.Get(ctx, key).Result()
+func (store *StatsStore) key(vin string) string {
+	return store.Namespace + vin
+}
+
+// Save serializes the stats now and writes them on the next Flush, replacing stats of the VIN saved before.
+func (store *StatsStore) Save(vin string, stats *statistics.NetworkStats) error {
+	serialized, err := json.Marshal(stats)
 	if err != nil {
 		sentry.CaptureException(err)
+		fmt.Println("Error serializing NetworkStats:", err)
+		return err
+	}
+
+	store.Lock()
+	defer store.Unlock()
+	store.Pending[vin] = serialized
+	return nil
+}
+
+func (store *StatsStore) Get(vin string) (*statistics.NetworkStats, error) {
+	stats, err := store.GetMany([]string{vin})
+	if err != nil {
+		return nil, err
+	}
+	return stats[vin], nil
+}
+
+// GetMany returns the pending stats and reads the others with a single MGET.
+func (store *StatsStore) GetMany(vins []string) (map[string]*statistics.NetworkStats, error) {
+	result := make(map[string]*statistics.NetworkStats, len(vins))
+	var missing []string
+	var keys []string
+
+	store.Lock()
+	for _, vin := range vins {
+		if serialized, ok := store.Pending[vin]; ok {
+			if stats := deserialize(serialized); stats != nil {
+				result[vin] = stats
+			}
+			continue
+		}
+		missing = append(missing, vin)
+		keys = append(keys, store.key(vin))
+	}
+	store.Unlock()
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	values, err := store.Client.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		sentry.CaptureException(err)
+		metrics.RedisError("get")
 		fmt.Println("Error getting NetworkStats from Redis:", err)
+		return result, err
+	}
+	for i, value := range values {
+		serialized, ok := value.(string)
+		if !ok {
+			continue // Key does not exist
+		}
+		if stats := deserialize([]byte(serialized)); stats != nil {
+			result[missing[i]] = stats
+		}
+	}
+	return result, nil
+}
+
+// Flush writes the pending stats in one pipeline. Stats which failed to be written are kept for the next Flush
+// unless newer ones were saved meanwhile.
+func (store *StatsStore) Flush(ctx context.Context) error {
+	store.Lock()
+	pending := store.Pending
+	store.Pending = make(map[string][]byte)
+	store.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	pipeline := store.Client.Pipeline()
+	for vin, serialized := range pending {
+		pipeline.Set(ctx, store.key(vin), serialized, store.TTL)
+	}
+	_, err := pipeline.Exec(ctx)
+	if err == nil {
 		return nil
 	}
 
-	// Deserialize the data
+	sentry.CaptureException(err)
+	metrics.RedisError("set")
+	fmt.Println("Error saving NetworkStats to Redis:", err)
+	store.Lock()
+	for vin, serialized := range pending {
+		if _, ok := store.Pending[vin]; !ok {
+			store.Pending[vin] = serialized
+		}
+	}
+	store.Unlock()
+	return err
+}
+
+func deserialize(serialized []byte) *statistics.NetworkStats {
 	stats := &statistics.NetworkStats{}
-	err = json.Unmarshal([]byte(serialized), stats)
+	err := json.Unmarshal(serialized, stats)
 	if err != nil {
 		sentry.CaptureException(err)
 		fmt.Println("Error deserializing NetworkStats:", err)
 		return nil
 	}
-
 	return stats
 }
 
-func SaveNetworkStats(key string, stats *statistics.NetworkStats) error {
-	ctx := context.Background()
-	db := GetDB() // Get the Redis client
+// SnapshotStore keeps the snapshot of the DataModel under one key.
+type SnapshotStore struct {
+	Client *r.Client
+	Key    string
+}
 
-	// Serialize the stats to JSON for storing
-	serialized, err := json.Marshal(stats)
-	if err != nil {
-		sentry.CaptureException(err)
-		fmt.Println("Error serializing NetworkStats:", err)
-		return err
+func NewSnapshotStore(client *r.Client, key string) *SnapshotStore {
+	return &SnapshotStore{
+		Client: client,
+		Key:    key,
 	}
+}
 
-	// Save the serialized data to Redis
-	err = db.Set(ctx, key, serialized, 0).Err()
+func (store *SnapshotStore) Save(ctx context.Context, data []byte) error {
+	err := store.Client.Set(ctx, store.Key, data, 0).Err()
 	if err != nil {
-		sentry.CaptureException(err)
-		fmt.Println("Error saving NetworkStats to Redis:", err)
-		return err
+		metrics.RedisError("set")
 	}
+	return err
+}
 
-	return nil
+// Load returns nil if there is no snapshot.
+func (store *SnapshotStore) Load(ctx context.Context) ([]byte, error) {
+	data, err := store.Client.Get(ctx, store.Key).Bytes()
+	if errors.Is(err, r.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		metrics.RedisError("get")
+		return nil, err
+	}
+	return data, nil
 }
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	statistics "car-integration/services/statistics"
+
+	"github.com/alicebob/miniredis/v2"
+	r "github.com/redis/go-redis/v9"
+)
+
+func newTestStatsStore(t *testing.T) (*StatsStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := r.NewClient(&r.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewStatsStore(client, "test:", time.Minute), server
+}
+
+func TestStatsStoreWritesOnFlush(t *testing.T) {
+	store, server := newTestStatsStore(t)
+
+	_ = store.Save("VIN1", &statistics.NetworkStats{PacketsReceived: 1})
+	if server.Exists("test:VIN1") {
+		t.Fatal("stats written before Flush")
+	}
+	// Pending stats are served without Redis
+	if stats, err := store.Get("VIN1"); err != nil || stats == nil || stats.PacketsReceived != 1 {
+		t.Fatalf("pending stats not returned: %+v, %v", stats, err)
+	}
+
+	err := store.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("test:VIN1") {
+		t.Fatal("stats not written by Flush")
+	}
+	if ttl := server.TTL("test:VIN1"); ttl != time.Minute {
+		t.Errorf("TTL of the key is %v, expected %v", ttl, time.Minute)
+	}
+	if len(store.Pending) != 0 {
+		t.Errorf("%v stats pending after Flush", len(store.Pending))
+	}
+
+	stats, err := store.GetMany([]string{"VIN1", "VIN2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats["VIN1"].PacketsReceived != 1 {
+		t.Errorf("GetMany returned %+v, expected the stats of VIN1 only", stats)
+	}
+}
+
+func TestStatsStoreCoalescesSaves(t *testing.T) {
+	store, _ := newTestStatsStore(t)
+
+	for i := int64(1); i <= 3; i++ {
+		_ = store.Save("VIN1", &statistics.NetworkStats{PacketsReceived: i})
+	}
+	if len(store.Pending) != 1 {
+		t.Fatalf("%v stats pending, expected 1", len(store.Pending))
+	}
+	if err := store.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := store.Get("VIN1")
+	if stats.PacketsReceived != 3 {
+		t.Errorf("stored %v packets, expected the last saved 3", stats.PacketsReceived)
+	}
+}
+
+func TestStatsStoreRetriesFailedWrites(t *testing.T) {
+	store, server := newTestStatsStore(t)
+
+	_ = store.Save("VIN1", &statistics.NetworkStats{PacketsReceived: 1})
+	_ = store.Save("VIN2", &statistics.NetworkStats{PacketsReceived: 1})
+	server.SetError("LOADING Redis is loading the dataset in memory")
+	if err := store.Flush(context.Background()); err == nil {
+		t.Fatal("Flush succeeded while Redis was failing")
+	}
+	if len(store.Pending) != 2 {
+		t.Fatalf("%v stats pending after the failed Flush, expected 2", len(store.Pending))
+	}
+
+	// Stats saved after the failure replace the ones which failed to be written
+	_ = store.Save("VIN1", &statistics.NetworkStats{PacketsReceived: 2})
+	server.SetError("")
+	if err := store.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := store.GetMany([]string{"VIN1", "VIN2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["VIN1"].PacketsReceived != 2 || stats["VIN2"].PacketsReceived != 1 {
+		t.Errorf("stored %+v and %+v, expected the newest stats", stats["VIN1"], stats["VIN2"])
+	}
+}
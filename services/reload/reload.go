@@ -0,0 +1,221 @@
+package reload
+
+import (
+	"car-integration/models"
+	communication "car-integration/services/communication"
+	config "car-integration/services/config"
+	routing "car-integration/services/routing"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Reloader applies a changed configuration file to the running Integration Module without dropping connections.
+// Area, allowed VINs, neighbours, vehicle routes and keep alive timeouts are applied,
+// the other settings are reported as requiring a restart.
+type Reloader struct {
+	sync.Mutex
+	ConfigFilepath string
+	Config         *config.Config // Currently applied configuration
+	DataModel      *communication.DataModel
+	Listeners      map[string]*communication.ConnectionsManager // Mapping listener name to its manager
+}
+
+// Change describes one applied setting.
+type Change struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+type Report struct {
+	Applied         []Change `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"` // Changed settings, which are not applied to the running module
+	RemovedVehicles []string `json:"removed_vehicles"` // Vehicles dropped from the DataModel as they are no longer allowed
+	RemovedZones    []string `json:"removed_zones"`    // Zones missing in the new area, vehicles inside of them exited them
+}
+
+func NewReloader(configFilepath string, cfg *config.Config, dataModel *communication.DataModel,
+	listeners map[string]*communication.ConnectionsManager) *Reloader {
+	return &Reloader{
+		ConfigFilepath: configFilepath,
+		Config:         cfg,
+		DataModel:      dataModel,
+		Listeners:      listeners,
+	}
+}
+
+// Reload reads the configuration file again and applies the changes.
+// All files are loaded and validated first, so nothing is applied if any of them is invalid.
+func (reloader *Reloader) Reload() (*Report, error) {
+	reloader.Lock()
+	defer reloader.Unlock()
+
+	newConfig, err := config.Load(reloader.ConfigFilepath)
+	if err != nil {
+		return nil, err
+	}
+	oldConfig := reloader.Config
+
+	area, err := LoadArea(newConfig.Area)
+	if err != nil {
+		return nil, fmt.Errorf("loading area: %w", err)
+	}
+	routes, err := routing.Load(newConfig.Routing.RoutesFile, newConfig.Routing.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("loading vehicle routes: %w", err)
+	}
+	var neighbours []models.Neighbour
+	if newConfig.Area.NeighboursFile != "" {
+		neighbours, err = models.LoadNeighbours(newConfig.Area.NeighboursFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading neighbours: %w", err)
+		}
+	}
+
+	report := &Report{
+		Applied:         []Change{},
+		RequiresRestart: []string{},
+		RemovedVehicles: []string{},
+		RemovedZones:    []string{},
+	}
+
+	// Compare the loaded area and neighbours, the files may have changed even if their paths did not
+	if !reflect.DeepEqual(reloader.DataModel.GetArea(true), area) {
+		report.Applied = append(report.Applied, Change{
+			Setting: "area",
+			Old:     fmt.Sprintf("%+v", oldConfig.Area),
+			New:     fmt.Sprintf("%+v", newConfig.Area),
+		})
+	}
+	report.add("allowed_vins", oldConfig.AllowedVins, newConfig.AllowedVins)
+
+	// Settings requiring restart keep their old values in the applied configuration, so they are reported until the restart
+	applied := *oldConfig
+	applied.Area = newConfig.Area
+	applied.AllowedVins = newConfig.AllowedVins
+	applied.Routing = newConfig.Routing
+
+	if handoff := reloader.DataModel.Handoff; handoff != nil {
+		report.add("neighbours", handoff.GetNeighbours(true), neighbours)
+	} else if newConfig.Area.NeighboursFile != "" {
+		report.RequiresRestart = append(report.RequiresRestart, "area.neighbours_file")
+		applied.Area.NeighboursFile = oldConfig.Area.NeighboursFile
+	}
+
+	report.add("routing", oldConfig.Routing, newConfig.Routing)
+
+	applied.Listeners = make([]config.ListenerConfig, len(oldConfig.Listeners))
+	copy(applied.Listeners, oldConfig.Listeners)
+	keepAliveTimeouts := make(map[string]float32) // Mapping listener name to its new timeout
+	newListeners := make(map[string]config.ListenerConfig)
+	for _, listener := range newConfig.Listeners {
+		newListeners[listener.Name] = listener
+	}
+	for i, oldListener := range applied.Listeners {
+		listener, ok := newListeners[oldListener.Name]
+		if !ok {
+			report.RequiresRestart = append(report.RequiresRestart, "listeners."+oldListener.Name+" (removed)")
+			continue
+		}
+		delete(newListeners, oldListener.Name)
+
+		if manager := reloader.Listeners[listener.Name]; manager != nil && listener.KeepAliveTimeout != oldListener.KeepAliveTimeout {
+			report.add("listeners."+listener.Name+".keep_alive_timeout", oldListener.KeepAliveTimeout, listener.KeepAliveTimeout)
+			keepAliveTimeouts[listener.Name] = listener.KeepAliveTimeout
+			applied.Listeners[i].KeepAliveTimeout = listener.KeepAliveTimeout
+		}
+		if !reflect.DeepEqual(applied.Listeners[i], listener) {
+			report.RequiresRestart = append(report.RequiresRestart, "listeners."+listener.Name)
+		}
+	}
+	for name := range newListeners {
+		report.RequiresRestart = append(report.RequiresRestart, "listeners."+name+" (added)")
+	}
+
+	restartSettings := map[string][2]interface{}{
+		"redis":             {oldConfig.Redis, newConfig.Redis},
+		"database":          {oldConfig.Database, newConfig.Database},
+		"sentry":            {oldConfig.Sentry, newConfig.Sentry},
+		"data_model":        {oldConfig.DataModel, newConfig.DataModel},
+		"reliable_delivery": {oldConfig.ReliableDelivery, newConfig.ReliableDelivery},
+		"debug_address":     {oldConfig.DebugAddress, newConfig.DebugAddress},
+		"health_address":    {oldConfig.HealthAddress, newConfig.HealthAddress},
+		"shutdown_timeout":  {oldConfig.ShutdownTimeout, newConfig.ShutdownTimeout},
+		"audit":             {oldConfig.Audit, newConfig.Audit},
+		"network_stats":     {oldConfig.NetworkStats, newConfig.NetworkStats},
+		"snapshot":          {oldConfig.Snapshot, newConfig.Snapshot},
+	}
+	for setting, values := range restartSettings {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			report.RequiresRestart = append(report.RequiresRestart, setting)
+		}
+	}
+	sort.Strings(report.RequiresRestart)
+
+	removedVehicles, removedZones := reloader.apply(area, newConfig.AllowedVins, neighbours, routes, keepAliveTimeouts)
+	report.RemovedVehicles = append(report.RemovedVehicles, removedVehicles...)
+	report.RemovedZones = append(report.RemovedZones, removedZones...)
+	reloader.Config = &applied
+	return report, nil
+}
+
+// apply replaces the reloadable settings at once. The managers of the listeners and the DataModel stay locked
+// until all of them are replaced, so no datagram is processed with a mix of the old and new configuration.
+// Returns VINs of the vehicles which are no longer allowed and names of the zones missing in the area.
+func (reloader *Reloader) apply(area *models.Area, allowedVins []string, neighbours []models.Neighbour,
+	routes map[string]routing.Route, keepAliveTimeouts map[string]float32) ([]string, []string) {
+	names := make([]string, 0, len(reloader.Listeners))
+	for name := range reloader.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		reloader.Listeners[name].Lock()
+		defer reloader.Listeners[name].Unlock()
+	}
+	reloader.DataModel.Lock()
+	defer reloader.DataModel.Unlock()
+
+	removedVehicles, removedZones := reloader.DataModel.Reconfigure(area, allowedVins, false)
+	if reloader.DataModel.Handoff != nil {
+		reloader.DataModel.Handoff.SetNeighbours(neighbours, true)
+	}
+	routing.SetRoutes(routes)
+	for name, timeout := range keepAliveTimeouts {
+		reloader.Listeners[name].SetKeepAliveTimeout(timeout, false)
+	}
+	return removedVehicles, removedZones
+}
+
+// add records the change of the setting if the values differ.
+func (report *Report) add(setting string, oldValue interface{}, newValue interface{}) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+	report.Applied = append(report.Applied, Change{
+		Setting: setting,
+		Old:     fmt.Sprintf("%+v", oldValue),
+		New:     fmt.Sprintf("%+v", newValue),
+	})
+}
+
+// LoadArea returns the area of the zones file, or the box of the corners if there is none.
+func LoadArea(areaConfig config.AreaConfig) (*models.Area, error) {
+	if areaConfig.ZonesFile != "" {
+		return models.LoadGeoJSON(areaConfig.ZonesFile)
+	}
+	return &models.Area{
+		TopLeft: api.PositionJSON{
+			Lat: areaConfig.TopLeft.Lat,
+			Lon: areaConfig.TopLeft.Lon,
+		},
+		BottomRight: api.PositionJSON{
+			Lat: areaConfig.BottomRight.Lat,
+			Lon: areaConfig.BottomRight.Lon,
+		},
+	}, nil
+}
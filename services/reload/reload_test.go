@@ -0,0 +1,203 @@
+package reload
+
+import (
+	communication "car-integration/services/communication"
+	config "car-integration/services/config"
+	routing "car-integration/services/routing"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+const (
+	pitLane = `{"type": "Feature", "properties": {"name": "pit-lane", "kind": "pit"},
+		"geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}}`
+	parking = `{"type": "Feature", "properties": {"name": "parking", "kind": "parking"},
+		"geometry": {"type": "Polygon", "coordinates": [[[2, 0], [3, 0], [3, 1], [2, 1]]]}}`
+)
+
+// writeFile writes the content into the directory and returns its path.
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeConfig writes the zones file with the features and the configuration file using it, followed by the extra YAML.
+func writeConfig(t *testing.T, dir string, extra string, features ...string) string {
+	t.Helper()
+	zonesFile := writeFile(t, dir, "zones.geojson", `{"type": "FeatureCollection", "features": [`+strings.Join(features, ",")+`]}`)
+	return writeFile(t, dir, "config.yaml", "area: {zones_file: "+zonesFile+"}\n"+extra)
+}
+
+// newTestReloader returns a reloader of the configuration with VIN1 parked and VIN2 in the pit lane, both with a decision.
+func newTestReloader(t *testing.T) (*Reloader, string) {
+	t.Helper()
+	dir := t.TempDir()
+	configFilepath := writeConfig(t, dir, "", pitLane, parking)
+	cfg, err := config.Load(configFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	area, err := LoadArea(cfg.Area)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataModel := communication.NewDataModel(area, 5)
+	positions := map[string]api.PositionJSON{"VIN1": {Lat: 0.5, Lon: 2.5}, "VIN2": {Lat: 0.5, Lon: 0.5}}
+	for vin, position := range positions {
+		timestamp := time.Now().UTC().Format(api.TimestampFormat)
+		dataModel.UpdateVehicle(nil, &api.UpdateVehicleDatagram{
+			BaseDatagram: api.BaseDatagram{Type: "update_vehicle", Timestamp: timestamp},
+			Vehicle:      api.UpdateVehicleVehicle{Vin: vin, Latitude: position.Lat, Longitude: position.Lon},
+		}, true)
+		dataModel.UpdateVehicleDecision(nil, &api.UpdateVehicleDecisionDatagram{
+			BaseDatagram:    api.BaseDatagram{Type: "decision_update", Timestamp: timestamp},
+			VehicleDecision: api.UpdateVehicleDecision{Vin: vin, Message: "stop"},
+		}, true)
+	}
+	t.Cleanup(func() { routing.SetRoutes(nil) })
+	return NewReloader(configFilepath, cfg, dataModel, map[string]*communication.ConnectionsManager{}), dir
+}
+
+// equal reports whether the lists have the same items, nil and empty lists are equal.
+func equal(got []string, want []string) bool {
+	return len(got) == 0 && len(want) == 0 || reflect.DeepEqual(got, want)
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name                string
+		extra               string
+		features            []string
+		wantApplied         []string
+		wantRequiresRestart []string
+		wantRemovedVehicles []string
+		wantRemovedZones    []string
+	}{
+		{"unchanged", "", []string{pitLane, parking}, nil, nil, nil, nil},
+		{"allowed vins", "allowed_vins: [VIN1, VIN3]\n", []string{pitLane, parking},
+			[]string{"allowed_vins"}, nil, []string{"VIN2"}, nil},
+		{"zone removed", "", []string{pitLane},
+			[]string{"area"}, nil, nil, []string{"parking"}},
+		{"zone added", "", []string{pitLane, parking, strings.ReplaceAll(parking, "parking", "garage")},
+			[]string{"area"}, nil, nil, nil},
+		{"routing", "routing: {routes: \"VIN1=127.0.0.1:9000\"}\n", []string{pitLane, parking},
+			[]string{"routing"}, nil, nil, nil},
+		{"settings requiring restart", "redis: {db: 2}\nshutdown_timeout: 3s\n", []string{pitLane, parking},
+			nil, []string{"redis", "shutdown_timeout"}, nil, nil},
+		{"listeners", "listeners:\n" +
+			"  - {name: vehicles, type: vehicle, bind_address: 0.0.0.0, port: 4040, ping_interval: 1s, reliable_delivery: true}\n" +
+			"  - {name: decision-module, type: processor, bind_address: 0.0.0.0, port: 6161, ping_interval: 1s}\n" +
+			"  - {name: simulator, type: processor, bind_address: 0.0.0.0, port: 4242}\n", []string{pitLane, parking},
+			nil, []string{"listeners.backend (removed)", "listeners.decision-module", "listeners.free-processor (removed)", "listeners.simulator (added)"}, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reloader, dir := newTestReloader(t)
+			writeConfig(t, dir, test.extra, test.features...)
+
+			report, err := reloader.Reload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var applied []string
+			for _, change := range report.Applied {
+				applied = append(applied, change.Setting)
+			}
+			if !equal(applied, test.wantApplied) {
+				t.Errorf("applied %v, want %v", applied, test.wantApplied)
+			}
+			if !equal(report.RequiresRestart, test.wantRequiresRestart) {
+				t.Errorf("requires restart %v, want %v", report.RequiresRestart, test.wantRequiresRestart)
+			}
+			if !equal(report.RemovedVehicles, test.wantRemovedVehicles) {
+				t.Errorf("removed vehicles %v, want %v", report.RemovedVehicles, test.wantRemovedVehicles)
+			}
+			if !equal(report.RemovedZones, test.wantRemovedZones) {
+				t.Errorf("removed zones %v, want %v", report.RemovedZones, test.wantRemovedZones)
+			}
+		})
+	}
+}
+
+func TestReloadRejectsInvalidFiles(t *testing.T) {
+	reloader, dir := newTestReloader(t)
+	applied := reloader.Config
+	writeConfig(t, dir, "allowed_vins: [VIN1]\n", `{"type": "Feature"}`)
+
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid zones file succeeded")
+	}
+	if reloader.Config != applied || reloader.DataModel.GetVehicleCount(true) != 2 {
+		t.Errorf("invalid reload changed the configuration or removed vehicles")
+	}
+}
+
+func TestReloadClearsStateOfRemovedVehiclesAndZones(t *testing.T) {
+	reloader, dir := newTestReloader(t)
+	dataModel := reloader.DataModel
+	sequence := dataModel.Geofence.NextSequence - 1
+	writeConfig(t, dir, "allowed_vins: [VIN1]\n", pitLane)
+
+	if _, err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	dataModel.Lock()
+	defer dataModel.Unlock()
+	if _, ok := dataModel.VehicleDecisions["VIN2"]; ok {
+		t.Error("decision of the removed vehicle kept")
+	}
+	if _, ok := dataModel.VehicleDecisionReceivedAt["VIN2"]; ok {
+		t.Error("decision receive time of the removed vehicle kept")
+	}
+	if _, ok := dataModel.DecisionGenerations["VIN2"]; ok {
+		t.Error("decision generation of the removed vehicle kept")
+	}
+	if _, ok := dataModel.Geofence.States["VIN2"]; ok {
+		t.Error("geofence state of the removed vehicle kept")
+	}
+	if _, ok := dataModel.Geofence.States["VIN1"]["parking"]; ok {
+		t.Error("geofence state of the removed zone kept")
+	}
+	if dataModel.DecisionGenerations["VIN1"] != 1 {
+		t.Errorf("decision generation of the kept vehicle is %v, want 1", dataModel.DecisionGenerations["VIN1"])
+	}
+
+	expected := []communication.GeofenceEvent{
+		{Vin: "VIN2", Zone: "pit-lane", ZoneKind: "pit", Reason: communication.GeofenceReasonRemoved, Position: api.PositionJSON{Lat: 0.5, Lon: 0.5}},
+		{Vin: "VIN1", Zone: "parking", ZoneKind: "parking", Reason: communication.GeofenceReasonZoneRemoved, Position: api.PositionJSON{Lat: 0.5, Lon: 2.5}},
+	}
+	events := dataModel.Geofence.EventsAfter(sequence)
+	if len(events) != len(expected) {
+		t.Fatalf("events %+v, want %v exits", events, len(expected))
+	}
+	for i, want := range expected {
+		event := events[i]
+		if event.Vin != want.Vin || event.Zone != want.Zone || event.ZoneKind != want.ZoneKind ||
+			event.Direction != communication.GeofenceExit || event.Reason != want.Reason || event.Position != want.Position {
+			t.Errorf("event %+v, want exit %+v", event, want)
+		}
+	}
+}
+
+func TestReconfigureWithSameAreaKeepsZones(t *testing.T) {
+	reloader, _ := newTestReloader(t)
+	dataModel := reloader.DataModel
+	sequence := dataModel.Geofence.NextSequence - 1
+
+	removedVehicles, removedZones := dataModel.Reconfigure(dataModel.GetArea(true), nil, true)
+	if len(removedVehicles) > 0 || len(removedZones) > 0 || len(dataModel.Geofence.EventsAfter(sequence)) > 0 {
+		t.Errorf("removed %v and %v, want nothing", removedVehicles, removedZones)
+	}
+}
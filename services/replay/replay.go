@@ -0,0 +1,166 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Entry is one datagram in the input log written by ConnectionsManager.LogInput.
+type Entry struct {
+	ReceivingPort  int    `json:"receivingPort"`
+	ConnectionType string `json:"connectionType"`
+	SourceIP       string `json:"sourceIP"`
+	SourcePort     int    `json:"sourcePort"`
+	Time           string `json:"time"`
+	Message        string `json:"message"`
+}
+
+// Options of the replay, empty filters match everything.
+type Options struct {
+	Target string  // Host the datagrams are sent to, each to its original receiving port
+	Speed  float64 // 1 for the original timing, 2 for twice as fast, 0 to send without delays
+	Vins   map[string]bool
+	Ports  map[int]bool
+	Types  map[string]bool
+}
+
+type Stats struct {
+	Read     int // Log lines read
+	Sent     int
+	Filtered int // Entries not matching the filters
+	Invalid  int // Lines which are not an input log entry
+}
+
+// datagramFields are the fields of any datagram used by the filters.
+type datagramFields struct {
+	api.BaseDatagram
+	Vehicle struct {
+		Vin string `json:"vin"`
+	} `json:"vehicle"`
+	VehicleDecision struct {
+		Vin string `json:"vin"`
+	} `json:"updateVehicleDecision"`
+}
+
+// Replay reads the input log and sends the matching datagrams to the target until the log ends or the context is done.
+// Every original source address gets its own socket, so the instance sees the same connections as during the recording.
+func Replay(ctx context.Context, reader io.Reader, options Options) (Stats, error) {
+	var stats Stats
+	sockets := make(map[string]*net.UDPConn)
+	defer func() {
+		for _, socket := range sockets {
+			_ = socket.Close()
+		}
+	}()
+
+	sourceVins := make(map[string]string) // VIN learned from the vehicle updates of each source
+	var firstTime, start time.Time
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 65536), 1024*1024)
+	for scanner.Scan() {
+		stats.Read++
+
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil || entry.Message == "" {
+			stats.Invalid++
+			continue
+		}
+		var fields datagramFields
+		err = json.Unmarshal([]byte(entry.Message), &fields)
+		if err != nil {
+			stats.Invalid++
+			continue
+		}
+		source := net.JoinHostPort(entry.SourceIP, strconv.Itoa(entry.SourcePort)) + "/" + strconv.Itoa(entry.ReceivingPort)
+		if fields.Vehicle.Vin != "" {
+			sourceVins[source] = fields.Vehicle.Vin
+		}
+		if !options.matches(&entry, &fields, sourceVins[source]) {
+			stats.Filtered++
+			continue
+		}
+
+		// Wait until the entry is due according to the time elapsed since the first sent entry
+		entryTime, err := parseTime(entry.Time)
+		if err == nil && options.Speed > 0 {
+			if firstTime.IsZero() {
+				firstTime, start = entryTime, time.Now()
+			}
+			due := start.Add(time.Duration(float64(entryTime.Sub(firstTime)) / options.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			}
+		}
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		socket, ok := sockets[source]
+		if !ok {
+			address, err := net.ResolveUDPAddr("udp", net.JoinHostPort(options.Target, strconv.Itoa(entry.ReceivingPort)))
+			if err != nil {
+				return stats, err
+			}
+			socket, err = net.DialUDP("udp", nil, address)
+			if err != nil {
+				return stats, err
+			}
+			sockets[source] = socket
+			go discardReplies(socket)
+		}
+		_, err = socket.Write([]byte(entry.Message))
+		if err != nil {
+			fmt.Printf("Failed to send datagram from %v: %v\n", source, err)
+			continue
+		}
+		stats.Sent++
+	}
+	return stats, scanner.Err()
+}
+
+// matches applies the filters, sourceVin attributes pings and acknowledgements of a vehicle to its VIN.
+func (options *Options) matches(entry *Entry, fields *datagramFields, sourceVin string) bool {
+	if len(options.Ports) > 0 && !options.Ports[entry.ReceivingPort] {
+		return false
+	}
+	if len(options.Types) > 0 && !options.Types[fields.Type] {
+		return false
+	}
+	if len(options.Vins) > 0 && !options.Vins[sourceVin] && !options.Vins[fields.VehicleDecision.Vin] {
+		return false
+	}
+	return true
+}
+
+// parseTime parses the time of the entry, written in api.TimestampFormat by the Integration Module.
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(api.TimestampFormat, value)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return parsed, nil
+}
+
+// discardReplies reads acknowledgements and updates sent by the instance, so they do not pile up in the socket.
+// Read errors caused by ICMP port unreachable are ignored, the instance may not be listening yet.
+func discardReplies(socket *net.UDPConn) {
+	buffer := make([]byte, 65536)
+	for {
+		_, err := socket.Read(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen returns a socket of the instance the datagrams are replayed to and its port.
+func listen(t *testing.T) (*net.UDPConn, int) {
+	t.Helper()
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Close() })
+	return socket, socket.LocalAddr().(*net.UDPAddr).Port
+}
+
+// logLine returns an input log entry of the message received on the port from the source port at the offset after 12:00.
+func logLine(t *testing.T, receivingPort int, sourcePort int, offset time.Duration, message string) string {
+	t.Helper()
+	line, err := json.Marshal(Entry{
+		ReceivingPort:  receivingPort,
+		ConnectionType: "vehicle",
+		SourceIP:       "10.0.0.1",
+		SourcePort:     sourcePort,
+		Time:           time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(offset).Format("2006-01-02T15:04:05.999Z"),
+		Message:        message,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line)
+}
+
+// receive returns the messages arriving at the socket until none arrives for a while.
+func receive(socket *net.UDPConn) []string {
+	var messages []string
+	buffer := make([]byte, 65536)
+	for {
+		_ = socket.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := socket.Read(buffer)
+		if err != nil {
+			return messages
+		}
+		messages = append(messages, string(buffer[:n]))
+	}
+}
+
+func TestReplayFilters(t *testing.T) {
+	vehicles, vehiclesPort := listen(t)
+	processors, processorsPort := listen(t)
+
+	const (
+		update1   = `{"type": "update_vehicle", "vehicle": {"vin": "VIN1"}}`
+		ping1     = `{"type": "ping"}`
+		update2   = `{"type": "update_vehicle", "vehicle": {"vin": "VIN2"}}`
+		decision2 = `{"type": "decision_update", "updateVehicleDecision": {"vin": "VIN2"}}`
+	)
+	log := strings.Join([]string{
+		logLine(t, vehiclesPort, 1001, 0, update1),
+		logLine(t, vehiclesPort, 1001, time.Millisecond, ping1),
+		logLine(t, vehiclesPort, 1002, 2*time.Millisecond, update2),
+		logLine(t, processorsPort, 2001, 3*time.Millisecond, decision2),
+		"not an entry",
+		`{"receivingPort": 4040, "message": "not a datagram"}`,
+		`{"receivingPort": 4040}`,
+	}, "\n")
+
+	tests := []struct {
+		name           string
+		options        Options
+		wantVehicles   []string
+		wantProcessors []string
+		wantFiltered   int
+	}{
+		{"no filters", Options{}, []string{update1, ping1, update2}, []string{decision2}, 0},
+		{"vin of updates, pings and decisions", Options{Vins: map[string]bool{"VIN1": true}}, []string{update1, ping1}, nil, 2},
+		{"vin of decision", Options{Vins: map[string]bool{"VIN2": true}}, []string{update2}, []string{decision2}, 2},
+		{"port", Options{Ports: map[int]bool{processorsPort: true}}, nil, []string{decision2}, 3},
+		{"type", Options{Types: map[string]bool{"update_vehicle": true, "decision_update": true}}, []string{update1, update2}, []string{decision2}, 1},
+		{"all filters", Options{Vins: map[string]bool{"VIN1": true}, Types: map[string]bool{"ping": true}, Ports: map[int]bool{vehiclesPort: true}}, []string{ping1}, nil, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.Target = "127.0.0.1"
+			stats, err := Replay(context.Background(), strings.NewReader(log), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Stats{Read: 7, Sent: len(test.wantVehicles) + len(test.wantProcessors), Filtered: test.wantFiltered, Invalid: 3}
+			if stats != want {
+				t.Errorf("stats %+v, want %+v", stats, want)
+			}
+
+			// Datagrams of different sources may overtake each other
+			gotVehicles := receive(vehicles)
+			sort.Strings(gotVehicles)
+			wantVehicles := append([]string{}, test.wantVehicles...)
+			sort.Strings(wantVehicles)
+			if len(gotVehicles)+len(wantVehicles) > 0 && !reflect.DeepEqual(gotVehicles, wantVehicles) {
+				t.Errorf("vehicles listener received %v, want %v", gotVehicles, wantVehicles)
+			}
+			if got := receive(processors); len(got)+len(test.wantProcessors) > 0 && !reflect.DeepEqual(got, test.wantProcessors) {
+				t.Errorf("processors listener received %v, want %v", got, test.wantProcessors)
+			}
+		})
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	_, port := listen(t)
+	log := strings.Join([]string{
+		logLine(t, port, 1001, 0, `{"type": "ping"}`),
+		logLine(t, port, 1001, 400*time.Millisecond, `{"type": "ping"}`),
+		logLine(t, port, 1001, 800*time.Millisecond, `{"type": "ping"}`),
+	}, "\n")
+
+	tests := []struct {
+		name        string
+		speed       float64
+		wantAtLeast time.Duration
+		wantAtMost  time.Duration
+	}{
+		{"original timing", 1, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"four times as fast", 4, 200 * time.Millisecond, 600 * time.Millisecond},
+		{"no delays", 0, 0, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			stats, err := Replay(context.Background(), strings.NewReader(log), Options{Target: "127.0.0.1", Speed: test.speed})
+			elapsed := time.Since(start)
+			if err != nil || stats.Sent != 3 {
+				t.Fatalf("Replay() sent %v, error %v", stats.Sent, err)
+			}
+			if elapsed < test.wantAtLeast || elapsed > test.wantAtMost {
+				t.Errorf("replay took %v, want between %v and %v", elapsed, test.wantAtLeast, test.wantAtMost)
+			}
+		})
+	}
+}
+
+func TestReplayStopsWithContext(t *testing.T) {
+	_, port := listen(t)
+	log := strings.Join([]string{
+		logLine(t, port, 1001, 0, `{"type": "ping"}`),
+		logLine(t, port, 1001, time.Hour, `{"type": "ping"}`),
+	}, "\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stats, err := Replay(ctx, strings.NewReader(log), Options{Target: "127.0.0.1", Speed: 1})
+	if !errors.Is(err, context.DeadlineExceeded) || stats.Sent != 1 {
+		t.Errorf("Replay() sent %v, error %v, want 1 sent before the deadline", stats.Sent, err)
+	}
+}
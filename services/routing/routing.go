@@ -0,0 +1,147 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// DefaultRoute is the key of the route used for vehicles without their own entry.
+const DefaultRoute = "*"
+
+// Route describes where datagrams for a vehicle are sent.
+// Empty Host or zero Port means the host or port the vehicle sends its datagrams from.
+type Route struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	IP   net.IP `json:"-"` // Host resolved by Load, so no lookup happens while sending
+	Zone string `json:"-"`
+}
+
+// Table maps VIN to the return address of the vehicle.
+type Table struct {
+	sync.Mutex
+	Routes map[string]Route
+}
+
+var table = &Table{Routes: make(map[string]Route)}
+
+// Init loads the routing table from the JSON file and from the route list,
+// formatted as "VIN=host:port;VIN=:port". Routes from the list take precedence over the file.
+// Without any route, datagrams are sent back to the source address of the vehicle.
+func Init(filepath string, list string) {
+	routes, err := Load(filepath, list)
+	if err != nil {
+		sentry.CaptureException(err)
+		fmt.Printf("Failed to load vehicle routes, replying to source addresses: %v\n", err)
+		return
+	}
+	SetRoutes(routes)
+}
+
+// Load reads routes from the JSON file (a map of VIN to route, skipped if the path is empty) and the route list,
+// and resolves their hosts.
+func Load(filepath string, list string) (map[string]Route, error) {
+	routes := make(map[string]Route)
+
+	if filepath != "" {
+		data, err := os.ReadFile(filepath)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &routes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %v: %w", filepath, err)
+		}
+	}
+
+	for _, entry := range strings.Split(list, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		vin, address, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route %q, expected VIN=host:port", entry)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+		}
+		route := Route{Host: host}
+		if port != "" {
+			route.Port, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in route %q: %w", entry, err)
+			}
+		}
+		routes[strings.TrimSpace(vin)] = route
+	}
+
+	for vin, route := range routes {
+		if route.Port < 0 || route.Port > 65535 {
+			return nil, fmt.Errorf("invalid port %v in route for %v", route.Port, vin)
+		}
+		if route.Host == "" {
+			continue
+		}
+		resolved, err := net.ResolveUDPAddr("udp", net.JoinHostPort(route.Host, "0"))
+		if err != nil {
+			return nil, fmt.Errorf("resolving host of route for %v: %w", vin, err)
+		}
+		route.IP = resolved.IP
+		route.Zone = resolved.Zone
+		routes[vin] = route
+	}
+	return routes, nil
+}
+
+// SetRoutes replaces the whole routing table, the routes have to be resolved by Load.
+func SetRoutes(routes map[string]Route) {
+	table.Lock()
+	defer table.Unlock()
+	table.Routes = routes
+}
+
+func GetRoutes() map[string]Route {
+	table.Lock()
+	defer table.Unlock()
+
+	routes := make(map[string]Route, len(table.Routes))
+	for vin, route := range table.Routes {
+		routes[vin] = route
+	}
+	return routes
+}
+
+// Resolve returns the address datagrams for the vehicle should be sent to.
+// The source address is never modified, a new address is returned instead. No lookup is done, it is safe to call
+// with the DataModel locked.
+func Resolve(vin string, source *net.UDPAddr) *net.UDPAddr {
+	table.Lock()
+	route, ok := table.Routes[vin]
+	if !ok {
+		route, ok = table.Routes[DefaultRoute]
+	}
+	table.Unlock()
+
+	address := *source
+	if !ok {
+		return &address
+	}
+
+	if route.IP != nil {
+		address.IP = route.IP
+		address.Zone = route.Zone
+	}
+	if route.Port != 0 {
+		address.Port = route.Port
+	}
+	return &address
+}
@@ -0,0 +1,108 @@
+package routing
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	err := os.WriteFile(routesFile, []byte(`{"VIN1": {"host": "10.0.0.1", "port": 1000}, "VIN2": {"port": 2000}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filepath string
+		list     string
+		want     map[string]Route
+	}{
+		{"nothing", "", "", map[string]Route{}},
+		{"file", routesFile, "", map[string]Route{
+			"VIN1": {Host: "10.0.0.1", Port: 1000, IP: net.ParseIP("10.0.0.1")},
+			"VIN2": {Port: 2000},
+		}},
+		{"list", "", " VIN1=10.0.0.2:3000; *=:12345 ;", map[string]Route{
+			"VIN1": {Host: "10.0.0.2", Port: 3000, IP: net.ParseIP("10.0.0.2")},
+			"*":    {Port: 12345},
+		}},
+		{"list overrides file", routesFile, "VIN1=10.0.0.2:", map[string]Route{
+			"VIN1": {Host: "10.0.0.2", IP: net.ParseIP("10.0.0.2")},
+			"VIN2": {Port: 2000},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := Load(test.filepath, test.list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(routes) != len(test.want) {
+				t.Fatalf("routes %+v, want %+v", routes, test.want)
+			}
+			for vin, want := range test.want {
+				route := routes[vin]
+				if route.Host != want.Host || route.Port != want.Port || !route.IP.Equal(want.IP) {
+					t.Errorf("route of %v %+v, want %+v", vin, route, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		filepath string
+		list     string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.json"), ""},
+		{"without VIN", "", "10.0.0.1:1000"},
+		{"without port separator", "", "VIN1=10.0.0.1"},
+		{"port not a number", "", "VIN1=10.0.0.1:port"},
+		{"port out of range", "", "VIN1=:70000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if routes, err := Load(test.filepath, test.list); err == nil {
+				t.Errorf("loaded %+v, expected an error", routes)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defer SetRoutes(make(map[string]Route))
+	source := &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: 5000}
+
+	tests := []struct {
+		name   string
+		routes string
+		vin    string
+		want   string
+	}{
+		{"no route", "", "VIN1", "192.168.0.10:5000"},
+		{"route of the vehicle", "VIN1=10.0.0.1:1000;*=:12345", "VIN1", "10.0.0.1:1000"},
+		{"default route", "VIN1=10.0.0.1:1000;*=:12345", "VIN2", "192.168.0.10:12345"},
+		{"host only", "VIN1=10.0.0.1:", "VIN1", "10.0.0.1:5000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := Load("", test.routes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetRoutes(routes)
+
+			if address := Resolve(test.vin, source); address.String() != test.want {
+				t.Errorf("Resolve(%v) = %v, want %v", test.vin, address, test.want)
+			}
+			if source.String() != "192.168.0.10:5000" {
+				t.Errorf("source address was modified to %v", source)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package simulator
+
+import (
+	"car-integration/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Options of the simulation.
+type Options struct {
+	Target         string        // Address of the vehicle listener of the Integration Module
+	Vehicles       int           // Number of simulated vehicles
+	UpdateInterval time.Duration // Interval of update_vehicle datagrams of every vehicle
+	Speed          float32       // Cruise speed in m/s
+	Area           *models.Area  // Random routes are generated inside the area
+	Routes         [][]api.PositionJSON
+	Impairments    Impairments
+}
+
+// Impairments of the network between the vehicles and the Integration Module, applied in both directions.
+type Impairments struct {
+	Loss        float64       // Probability of dropping a datagram
+	Duplicate   float64       // Probability of sending a datagram twice
+	Delay       time.Duration // Constant delay of every datagram
+	Jitter      time.Duration // Random delay added to Delay, reorders datagrams if larger than the update interval
+	ClockOffset time.Duration // Offset of the vehicle clocks against the clock of the module
+}
+
+type Stats struct {
+	Sent      int64
+	Dropped   int64
+	Received  int64
+	Decisions int64
+	Pings     int64
+}
+
+// Simulation runs the vehicles, see Run.
+type Simulation struct {
+	sync.Mutex
+	Options  Options
+	Vehicles []*Vehicle
+	Stats    Stats
+	random   *rand.Rand
+}
+
+// routePoints is the number of waypoints of generated routes.
+const routePoints = 8
+
+func NewSimulation(options Options) (*Simulation, error) {
+	target, err := net.ResolveUDPAddr("udp", options.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	simulation := &Simulation{
+		Options: options,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for i := 0; i < options.Vehicles; i++ {
+		var route []api.PositionJSON
+		if len(options.Routes) > 0 {
+			route = options.Routes[i%len(options.Routes)]
+		} else {
+			route = simulation.randomRoute()
+		}
+		vehicle, err := NewVehicle(simulation, fmt.Sprintf("SIM%014d", i+1), route, target)
+		if err != nil {
+			return nil, err
+		}
+		simulation.Vehicles = append(simulation.Vehicles, vehicle)
+	}
+	return simulation, nil
+}
+
+// Run drives the vehicles until the context is done.
+func (simulation *Simulation) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, vehicle := range simulation.Vehicles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vehicle.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (simulation *Simulation) GetStats() Stats {
+	simulation.Lock()
+	defer simulation.Unlock()
+	return simulation.Stats
+}
+
+// LoadRoutes reads a JSON list of routes, each a list of {"lat", "lon"} waypoints driven in a loop.
+func LoadRoutes(filepath string) ([][]api.PositionJSON, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	var routes [][]api.PositionJSON
+	err = json.Unmarshal(data, &routes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", filepath, err)
+	}
+	for i, route := range routes {
+		if len(route) == 0 {
+			return nil, fmt.Errorf("route %v has no waypoints", i)
+		}
+	}
+	return routes, nil
+}
+
+// randomRoute returns waypoints inside the area, or around its corner if the area is empty.
+func (simulation *Simulation) randomRoute() []api.PositionJSON {
+	area := simulation.Options.Area
+	topLeft, bottomRight := area.TopLeft, area.BottomRight
+	if area.Polygon != nil {
+		topLeft, bottomRight = area.Polygon.Bounds()
+	}
+	lonSpan := float64(bottomRight.Lon - topLeft.Lon)
+	if lonSpan < 0 {
+		lonSpan += 360 // Box crossing the antimeridian
+	}
+	latSpan := float64(topLeft.Lat - bottomRight.Lat)
+	degenerate := lonSpan == 0 || latSpan == 0
+	if degenerate {
+		latSpan, lonSpan = 0.005, 0.005
+	}
+
+	route := make([]api.PositionJSON, 0, routePoints)
+	for len(route) < routePoints {
+		// Rejection sampling for polygons, a few attempts per waypoint are enough for reasonable shapes
+		var position api.PositionJSON
+		for attempt := 0; attempt < 100; attempt++ {
+			lon := float64(topLeft.Lon) + simulation.random.Float64()*lonSpan
+			if lon > 180 {
+				lon -= 360
+			}
+			position = api.PositionJSON{
+				Lat: float32(float64(topLeft.Lat) - simulation.random.Float64()*latSpan),
+				Lon: float32(lon),
+			}
+			if degenerate || area.Contains(&position) {
+				break
+			}
+		}
+		route = append(route, position)
+	}
+	return route
+}
+
+// transmit applies the impairments and calls send once per delivered copy of the datagram.
+func (simulation *Simulation) transmit(send func()) {
+	impairments := simulation.Options.Impairments
+
+	simulation.Lock()
+	if simulation.random.Float64() < impairments.Loss {
+		simulation.Stats.Dropped++
+		simulation.Unlock()
+		return
+	}
+	copies := 1
+	if simulation.random.Float64() < impairments.Duplicate {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = impairments.Delay
+		if impairments.Jitter > 0 {
+			delays[i] += time.Duration(simulation.random.Int63n(int64(impairments.Jitter)))
+		}
+	}
+	simulation.Unlock()
+
+	for _, delay := range delays {
+		if delay <= 0 {
+			send()
+		} else {
+			time.AfterFunc(delay, send)
+		}
+	}
+}
+
+// dropIncoming reports whether the received datagram is lost by the impairments.
+func (simulation *Simulation) dropIncoming() bool {
+	simulation.Lock()
+	defer simulation.Unlock()
+	if simulation.random.Float64() < simulation.Options.Impairments.Loss {
+		simulation.Stats.Dropped++
+		return true
+	}
+	simulation.Stats.Received++
+	return false
+}
+
+// distance returns the distance in meters between the positions, using the equirectangular approximation.
+func distance(from api.PositionJSON, to api.PositionJSON) float64 {
+	north, east := offset(from, to)
+	return math.Hypot(north, east)
+}
+
+// offset returns the north and east offset in meters of the position to from the position from.
+func offset(from api.PositionJSON, to api.PositionJSON) (north float64, east float64) {
+	lonDelta := float64(to.Lon - from.Lon)
+	if lonDelta > 180 {
+		lonDelta -= 360
+	} else if lonDelta < -180 {
+		lonDelta += 360
+	}
+	north = float64(to.Lat-from.Lat) * metersPerDegree
+	east = lonDelta * metersPerDegree * math.Cos(float64(from.Lat)*math.Pi/180)
+	return north, east
+}
+
+const metersPerDegree = 111320
@@ -0,0 +1,267 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// Vehicle drives along its route in a loop and sends its state to the Integration Module.
+type Vehicle struct {
+	sync.Mutex
+	Simulation    *Simulation
+	Vin           string
+	Route         []api.PositionJSON
+	NextWaypoint  int
+	Position      api.PositionJSON
+	Heading       float64 // Degrees clockwise from north
+	HeadingOffset float64 // Deviation from the route requested by a decision, decays back to 0
+	Speed         float64 // Current speed in m/s
+	TargetSpeed   float64 // Speed the vehicle accelerates or brakes to
+	NextIndex     int
+	Target        *net.UDPAddr // Vehicle listener of the Integration Module, changed by disconnect_vehicle
+	UDPConn       *net.UDPConn
+}
+
+// Dynamics of the simulated vehicles
+const (
+	acceleration    = 3.0  // m/s²
+	headingRecovery = 15.0 // Degrees per second the heading offset decays
+	turnOffset      = 45.0 // Degrees of a turn requested by a decision
+)
+
+func NewVehicle(simulation *Simulation, vin string, route []api.PositionJSON, target *net.UDPAddr) (*Vehicle, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	speed := float64(simulation.Options.Speed)
+	return &Vehicle{
+		Simulation:   simulation,
+		Vin:          vin,
+		Route:        route,
+		NextWaypoint: 1 % len(route),
+		Position:     route[0],
+		Speed:        speed,
+		TargetSpeed:  speed,
+		NextIndex:    1,
+		Target:       target,
+		UDPConn:      conn,
+	}, nil
+}
+
+// Run sends updates every update interval and answers datagrams of the module until the context is done.
+func (vehicle *Vehicle) Run(ctx context.Context) {
+	go vehicle.listen()
+	defer vehicle.UDPConn.Close()
+
+	interval := vehicle.Simulation.Options.UpdateInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			vehicle.move(interval.Seconds())
+			vehicle.sendUpdate()
+		}
+	}
+}
+
+// move advances the vehicle towards its next waypoint.
+func (vehicle *Vehicle) move(seconds float64) {
+	vehicle.Lock()
+	defer vehicle.Unlock()
+
+	if vehicle.Speed < vehicle.TargetSpeed {
+		vehicle.Speed = min(vehicle.Speed+acceleration*seconds, vehicle.TargetSpeed)
+	} else {
+		vehicle.Speed = max(vehicle.Speed-acceleration*seconds, vehicle.TargetSpeed)
+	}
+	if vehicle.HeadingOffset > 0 {
+		vehicle.HeadingOffset = max(vehicle.HeadingOffset-headingRecovery*seconds, 0)
+	} else {
+		vehicle.HeadingOffset = min(vehicle.HeadingOffset+headingRecovery*seconds, 0)
+	}
+
+	remaining := vehicle.Speed * seconds
+	for remaining > 0 && len(vehicle.Route) > 1 {
+		waypoint := vehicle.Route[vehicle.NextWaypoint]
+		north, east := offset(vehicle.Position, waypoint)
+		vehicle.Heading = math.Mod(math.Atan2(east, north)*180/math.Pi+vehicle.HeadingOffset+360, 360)
+
+		toWaypoint := math.Hypot(north, east)
+		if toWaypoint <= remaining {
+			vehicle.Position = waypoint
+			vehicle.NextWaypoint = (vehicle.NextWaypoint + 1) % len(vehicle.Route)
+			remaining -= toWaypoint
+			continue
+		}
+
+		heading := vehicle.Heading * math.Pi / 180
+		vehicle.Position.Lat += float32(remaining * math.Cos(heading) / metersPerDegree)
+		vehicle.Position.Lon += float32(remaining * math.Sin(heading) / (metersPerDegree * math.Cos(float64(vehicle.Position.Lat)*math.Pi/180)))
+		remaining = 0
+	}
+}
+
+func (vehicle *Vehicle) sendUpdate() {
+	vehicle.Lock()
+	speed := float32(vehicle.Speed)
+	datagram := &api.UpdateVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "update_vehicle"},
+		Vehicle: api.UpdateVehicleVehicle{
+			Vin:                   vehicle.Vin,
+			Latitude:              vehicle.Position.Lat,
+			Longitude:             vehicle.Position.Lon,
+			GpsDirection:          float32(vehicle.Heading),
+			GpsSatelliteCount:     12,
+			GpsHorizontalAccuracy: 1,
+			Speed:                 speed,
+			SpeedFrontLeft:        speed,
+			SpeedFrontRight:       speed,
+			SpeedRearLeft:         speed,
+			SpeedRearRight:        speed,
+		},
+	}
+	vehicle.Unlock()
+	vehicle.write(datagram)
+}
+
+// write sends the datagram with the next index and the timestamp of the vehicle clock.
+func (vehicle *Vehicle) write(datagram api.IDatagram) {
+	vehicle.Lock()
+	datagram.SetIndex(vehicle.NextIndex)
+	vehicle.NextIndex++
+	datagram.SetTimestamp(vehicle.now().Format(api.TimestampFormat))
+	target := vehicle.Target
+	vehicle.Unlock()
+
+	data, err := json.Marshal(datagram)
+	if err != nil {
+		fmt.Printf("Error marshalling datagram %v with error %v\n", datagram, err)
+		return
+	}
+
+	simulation := vehicle.Simulation
+	simulation.transmit(func() {
+		_, err := vehicle.UDPConn.WriteToUDP(data, target)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Printf("Vehicle %v failed to send datagram: %v\n", vehicle.Vin, err)
+			return
+		}
+		simulation.Lock()
+		simulation.Stats.Sent++
+		simulation.Unlock()
+	})
+}
+
+// now returns the time of the vehicle clock.
+func (vehicle *Vehicle) now() time.Time {
+	return time.Now().UTC().Add(vehicle.Simulation.Options.Impairments.ClockOffset)
+}
+
+// listen answers pings, acknowledges and applies decisions and follows disconnect_vehicle.
+func (vehicle *Vehicle) listen() {
+	readBuffer := make([]byte, 65536)
+	for {
+		readBufferLength, _, err := vehicle.UDPConn.ReadFromUDP(readBuffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil || vehicle.Simulation.dropIncoming() {
+			continue
+		}
+		data := readBuffer[:readBufferLength]
+
+		var datagram api.BaseDatagram
+		if json.Unmarshal(data, &datagram) != nil {
+			continue
+		}
+
+		switch datagram.Type {
+		case "ping":
+			vehicle.Simulation.Lock()
+			vehicle.Simulation.Stats.Pings++
+			vehicle.Simulation.Unlock()
+			vehicle.acknowledge(datagram.Index)
+
+		// Decisions are forwarded to vehicles as update_vehicle_position
+		case "update_vehicle_position", "decision_update":
+			var decisionDatagram api.UpdateVehicleDecisionDatagram
+			_ = json.Unmarshal(data, &decisionDatagram)
+			vehicle.acknowledge(datagram.Index)
+			vehicle.Simulation.Lock()
+			vehicle.Simulation.Stats.Decisions++
+			vehicle.Simulation.Unlock()
+			vehicle.applyDecision(decisionDatagram.VehicleDecision.Message)
+
+		case "disconnect_vehicle":
+			var disconnectDatagram api.DisconnectVehicleDatagram
+			_ = json.Unmarshal(data, &disconnectDatagram)
+			vehicle.acknowledge(datagram.Index)
+			vehicle.reconnect(disconnectDatagram.ConnectTo)
+		}
+	}
+}
+
+func (vehicle *Vehicle) acknowledge(index int) {
+	vehicle.write(&api.AcknowledgeDatagram{
+		BaseDatagram:       api.BaseDatagram{Type: "acknowledge"},
+		AcknowledgingIndex: index,
+	})
+}
+
+// applyDecision changes the target speed or heading by the keywords of the free text decision message.
+func (vehicle *Vehicle) applyDecision(message string) {
+	vehicle.Lock()
+	defer vehicle.Unlock()
+
+	cruise := float64(vehicle.Simulation.Options.Speed)
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(message), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		words[word] = true
+	}
+	switch {
+	case words["stop"], words["brake"]:
+		vehicle.TargetSpeed = 0
+	case words["slow"]:
+		vehicle.TargetSpeed = cruise / 2
+	case words["go"], words["resume"], words["continue"]:
+		vehicle.TargetSpeed = cruise
+	}
+	switch {
+	case words["left"]:
+		vehicle.HeadingOffset = -turnOffset
+	case words["right"]:
+		vehicle.HeadingOffset = turnOffset
+	}
+	fmt.Printf("Vehicle %v decision %q, target speed %.1f m/s\n", vehicle.Vin, message, vehicle.TargetSpeed)
+}
+
+// reconnect switches the vehicle to the instance the module handed it off to.
+func (vehicle *Vehicle) reconnect(connectTo string) {
+	if connectTo == "" {
+		fmt.Printf("Vehicle %v disconnected by the module\n", vehicle.Vin)
+		return
+	}
+	target, err := net.ResolveUDPAddr("udp", connectTo)
+	if err != nil {
+		fmt.Printf("Vehicle %v cannot connect to %v: %v\n", vehicle.Vin, connectTo, err)
+		return
+	}
+	vehicle.Lock()
+	vehicle.Target = target
+	vehicle.Unlock()
+	fmt.Printf("Vehicle %v reconnecting to %v\n", vehicle.Vin, connectTo)
+}
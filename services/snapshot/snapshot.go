@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	communication "car-integration/services/communication"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+	"github.com/getsentry/sentry-go"
+)
+
+// Store persists the serialized snapshot.
+type Store interface {
+	Save(ctx context.Context, data []byte) error
+	// Load returns nil if no snapshot was saved.
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// FileStore keeps the snapshot in a file, replaced atomically by every Save.
+type FileStore struct {
+	Path string
+}
+
+func (store *FileStore) Save(ctx context.Context, data []byte) error {
+	if dir := filepath.Dir(store.Path); dir != "." {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	temporary := store.Path + ".tmp"
+	err := os.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, store.Path)
+}
+
+func (store *FileStore) Load(ctx context.Context) ([]byte, error) {
+	data, err := os.ReadFile(store.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Snapshotter saves snapshots of the DataModel every interval and restores the last one on start.
+type Snapshotter struct {
+	DataModel    *communication.DataModel
+	Store        Store
+	Interval     time.Duration
+	MaxAge       time.Duration // Older snapshots are not restored, the vehicles are long gone
+	StaleTimeout time.Duration // Restored vehicles which do not send an update within it are removed
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSnapshotter(dataModel *communication.DataModel, store Store, interval time.Duration, maxAge time.Duration, staleTimeout time.Duration) *Snapshotter {
+	return &Snapshotter{
+		DataModel:    dataModel,
+		Store:        store,
+		Interval:     interval,
+		MaxAge:       maxAge,
+		StaleTimeout: staleTimeout,
+	}
+}
+
+// Restore loads the last snapshot into the DataModel and schedules the removal of the vehicles which stay stale.
+// Returns the VINs of the restored vehicles.
+func (snapshotter *Snapshotter) Restore(ctx context.Context) ([]string, error) {
+	data, err := snapshotter.Store.Load(ctx)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var snapshot communication.Snapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("parsing snapshot: %w", err)
+	}
+	takenAt, err := time.Parse(api.TimestampFormat, snapshot.TakenAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing time of the snapshot: %w", err)
+	}
+	if age := time.Since(takenAt); age > snapshotter.MaxAge {
+		fmt.Printf("Snapshot taken %v ago is older than %v, starting empty\n", age.Round(time.Second), snapshotter.MaxAge)
+		return nil, nil
+	}
+
+	restored := snapshotter.DataModel.Restore(&snapshot, true)
+	if len(restored) > 0 && snapshotter.StaleTimeout > 0 {
+		time.AfterFunc(snapshotter.StaleTimeout, func() {
+			removed := snapshotter.DataModel.RemoveStaleVehicles(true)
+			if len(removed) > 0 {
+				fmt.Printf("Removed %v restored vehicles which did not reconnect: %v\n", len(removed), removed)
+			}
+		})
+	}
+	return restored, nil
+}
+
+// Save takes a snapshot of the DataModel and saves it.
+func (snapshotter *Snapshotter) Save(ctx context.Context) error {
+	data, err := json.Marshal(snapshotter.DataModel.TakeSnapshot())
+	if err != nil {
+		return err
+	}
+	return snapshotter.Store.Save(ctx, data)
+}
+
+// Start saves a snapshot every interval until Stop.
+func (snapshotter *Snapshotter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshotter.cancel = cancel
+	snapshotter.done = make(chan struct{})
+
+	go func() {
+		defer close(snapshotter.done)
+		ticker := time.NewTicker(snapshotter.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := snapshotter.Save(ctx)
+				if err != nil && ctx.Err() == nil {
+					sentry.CaptureException(err)
+					fmt.Printf("Failed to save snapshot: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic snapshots and saves the final one. It has to be called before the listeners are drained,
+// as they remove the vehicles of the closed connections.
+func (snapshotter *Snapshotter) Stop(ctx context.Context) error {
+	if snapshotter.cancel != nil {
+		snapshotter.cancel()
+		<-snapshotter.done
+	}
+	return snapshotter.Save(ctx)
+}
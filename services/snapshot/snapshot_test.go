@@ -0,0 +1,206 @@
+package snapshot
+
+import (
+	"car-integration/models"
+	communication "car-integration/services/communication"
+	statistics "car-integration/services/statistics"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// updateVehicle sends an update of the vehicle at the time to the DataModel.
+func updateVehicle(dataModel *communication.DataModel, vin string, at time.Time) {
+	dataModel.UpdateVehicle(nil, &api.UpdateVehicleDatagram{
+		BaseDatagram: api.BaseDatagram{Type: "update_vehicle", Timestamp: at.UTC().Format(api.TimestampFormat)},
+		Vehicle:      api.UpdateVehicleVehicle{Vin: vin, Latitude: 48.15, Longitude: 17.07, Speed: 2},
+	}, true)
+}
+
+// newTestStore returns a file store in a directory which does not exist yet.
+func newTestStore(t *testing.T) *FileStore {
+	return &FileStore{Path: filepath.Join(t.TempDir(), "state", "snapshot.json")}
+}
+
+// saveSnapshot saves a snapshot taken at the time with the vehicles into the store.
+func saveSnapshot(t *testing.T, store Store, takenAt time.Time, vins ...string) {
+	t.Helper()
+	snapshot := communication.Snapshot{
+		TakenAt:  takenAt.UTC().Format(api.TimestampFormat),
+		Vehicles: make(map[string]communication.Vehicle),
+	}
+	for _, vin := range vins {
+		snapshot.Vehicles[vin] = communication.Vehicle{
+			UpdateVehicleVehicle: api.UpdateVehicleVehicle{Vin: vin},
+			Timestamp:            snapshot.TakenAt,
+		}
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	dataModel := communication.NewDataModel(&models.Area{}, 5)
+	for _, vin := range []string{"VIN1", "VIN2"} {
+		updateVehicle(dataModel, vin, time.Now())
+	}
+	dataModel.UpdateVehicleDecision(nil, &api.UpdateVehicleDecisionDatagram{
+		BaseDatagram:    api.BaseDatagram{Type: "decision_update", Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+		VehicleDecision: api.UpdateVehicleDecision{Vin: "VIN1", Message: "stop"},
+	}, true)
+	_ = dataModel.StatsStore.Save("VIN1", &statistics.NetworkStats{PacketsReceived: 42})
+
+	if err := NewSnapshotter(dataModel, store, time.Second, time.Minute, 0).Save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	restoredModel := communication.NewDataModel(&models.Area{}, 5)
+	restored, err := NewSnapshotter(restoredModel, store, time.Second, time.Minute, 0).Restore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(restored)
+	if !reflect.DeepEqual(restored, []string{"VIN1", "VIN2"}) {
+		t.Fatalf("restored %v, want VIN1 and VIN2", restored)
+	}
+
+	vehicles, decisions := restoredModel.GetVehicleStates(true)
+	original, _ := dataModel.GetVehicleStates(true)
+	for vin, vehicle := range vehicles {
+		if !vehicle.Stale || vehicle.RestoredAt == "" {
+			t.Errorf("%v restored as %+v, want stale", vin, vehicle)
+		}
+		if vehicle.UpdateVehicleVehicle != original[vin].UpdateVehicleVehicle || vehicle.Timestamp != original[vin].Timestamp {
+			t.Errorf("%v restored as %+v, want %+v", vin, vehicle, original[vin])
+		}
+	}
+	if decision, ok := decisions["VIN1"]; !ok || decision.Message != "stop" {
+		t.Errorf("decision of VIN1 restored as %+v, want stop", decision)
+	}
+	if _, ok := decisions["VIN2"]; ok {
+		t.Error("VIN2 restored with a decision it never had")
+	}
+	if stats, err := restoredModel.StatsStore.Get("VIN1"); err != nil || stats == nil || stats.PacketsReceived != 42 {
+		t.Errorf("network stats of VIN1 restored as %+v, error %v", stats, err)
+	}
+	if restoredModel.NextVehicleId != dataModel.NextVehicleId {
+		t.Errorf("next vehicle id %v, want %v", restoredModel.NextVehicleId, dataModel.NextVehicleId)
+	}
+}
+
+func TestRestoreKeepsNewerVehicles(t *testing.T) {
+	store := newTestStore(t)
+	saveSnapshot(t, store, time.Now(), "VIN1", "VIN2")
+
+	dataModel := communication.NewDataModel(&models.Area{}, 5)
+	updateVehicle(dataModel, "VIN1", time.Now())
+	restored, err := NewSnapshotter(dataModel, store, time.Second, time.Minute, 0).Restore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vehicles, _ := dataModel.GetVehicleStates(true)
+	if !reflect.DeepEqual(restored, []string{"VIN2"}) || vehicles["VIN1"].Stale {
+		t.Errorf("restored %v with VIN1 %+v, want only VIN2 and VIN1 kept up to date", restored, vehicles["VIN1"])
+	}
+}
+
+func TestRestoreMaxAge(t *testing.T) {
+	tests := []struct {
+		name         string
+		age          time.Duration
+		wantRestored bool
+	}{
+		{"fresh", time.Second, true},
+		{"just below max age", 9 * time.Minute, true},
+		{"older than max age", 11 * time.Minute, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			saveSnapshot(t, store, time.Now().Add(-test.age), "VIN1")
+			dataModel := communication.NewDataModel(&models.Area{}, 5)
+
+			restored, err := NewSnapshotter(dataModel, store, time.Second, 10*time.Minute, 0).Restore(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(restored) == 1) != test.wantRestored || (dataModel.GetVehicleCount(true) == 1) != test.wantRestored {
+				t.Errorf("restored %v, want restored %v", restored, test.wantRestored)
+			}
+		})
+	}
+}
+
+func TestRestoreWithoutSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"no snapshot", "", false},
+		{"invalid snapshot", "{", true},
+		{"invalid time", `{"taken_at": "yesterday"}`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if test.data != "" {
+				if err := store.Save(context.Background(), []byte(test.data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dataModel := communication.NewDataModel(&models.Area{}, 5)
+			restored, err := NewSnapshotter(dataModel, store, time.Second, time.Minute, 0).Restore(context.Background())
+			if (err != nil) != test.wantErr || len(restored) > 0 {
+				t.Errorf("Restore() = %v, error %v, want error %v", restored, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStaleTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		staleTimeout time.Duration
+		wantVins     []string
+	}{
+		{"stale vehicles removed", 100 * time.Millisecond, []string{"VIN1"}},
+		{"no timeout", 0, []string{"VIN1", "VIN2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			saveSnapshot(t, store, time.Now(), "VIN1", "VIN2")
+			dataModel := communication.NewDataModel(&models.Area{}, 5)
+
+			_, err := NewSnapshotter(dataModel, store, time.Second, time.Minute, test.staleTimeout).Restore(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			updateVehicle(dataModel, "VIN1", time.Now()) // VIN1 reconnects, VIN2 stays stale
+			time.Sleep(300 * time.Millisecond)
+
+			vehicles, _ := dataModel.GetVehicleStates(true)
+			var vins []string
+			for vin := range vehicles {
+				vins = append(vins, vin)
+			}
+			sort.Strings(vins)
+			if !reflect.DeepEqual(vins, test.wantVins) {
+				t.Errorf("vehicles %v after the stale timeout, want %v", vins, test.wantVins)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package statistics
+
+import "time"
+
+// clockSamples is the number of the latest ping exchanges the clock offset is estimated from.
+const clockSamples = 8
+
+// ClockSample is one ping exchange: sent and received by the Integration Module, answered by the client at RemoteTime.
+type ClockSample struct {
+	Offset    time.Duration // Client clock minus Integration Module clock
+	RoundTrip time.Duration
+}
+
+// ClockEstimator estimates the offset of a client clock NTP-style. The sample with the lowest round trip time
+// of the last clockSamples is used, as its offset is the least affected by asymmetric delays.
+type ClockEstimator struct {
+	Samples []ClockSample
+}
+
+// Add records a ping exchange. The client is assumed to have answered in the middle of the round trip.
+func (estimator *ClockEstimator) Add(sentAt time.Time, remoteTime time.Time, receivedAt time.Time) {
+	roundTrip := receivedAt.Sub(sentAt)
+	if roundTrip < 0 {
+		return
+	}
+	sample := ClockSample{
+		Offset:    remoteTime.Sub(sentAt.Add(roundTrip / 2)),
+		RoundTrip: roundTrip,
+	}
+	estimator.Samples = append(estimator.Samples, sample)
+	if len(estimator.Samples) > clockSamples {
+		estimator.Samples = estimator.Samples[len(estimator.Samples)-clockSamples:]
+	}
+}
+
+// Estimate returns the offset, its error bound (half of the round trip of the used sample, the offset
+// can be off at most by that much) and the latest round trip time. ok is false without any sample.
+func (estimator *ClockEstimator) Estimate() (offset time.Duration, errorBound time.Duration, roundTrip time.Duration, ok bool) {
+	if len(estimator.Samples) == 0 {
+		return 0, 0, 0, false
+	}
+	best := estimator.Samples[0]
+	for _, sample := range estimator.Samples[1:] {
+		if sample.RoundTrip < best.RoundTrip {
+			best = sample
+		}
+	}
+	return best.Offset, best.RoundTrip / 2, estimator.Samples[len(estimator.Samples)-1].RoundTrip, true
+}
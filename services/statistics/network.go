@@ -2,6 +2,7 @@ package statistics
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	api "github.com/TP-TEAM05/integration-api"
@@ -19,24 +20,68 @@ type NetworkStats struct {
 	PrevPacketTime time.Time
 	LastDelay      time.Duration
 	Jitter         time.Duration
+	// packet sequence derived from datagram indices
+	HighestIndex    int
+	LowestIndex     int // First index since the start or the last reset, lower ones were never expected
+	PacketsExpected int64
+	PacketsLost     int64 // Indices skipped and not received later
+	LossRate        float64
+	OutOfOrder      int64
+	Duplicates      int64
+	LongestGap      int // Most consecutive indices lost at once
+	SequenceResets  int64
+	// clock offset of the vehicle estimated from ping exchanges, latency is corrected by it once known
+	ClockOffset      time.Duration // Vehicle clock minus Integration Module clock
+	ClockOffsetError time.Duration // The offset is off by at most this much
+	RoundTripTime    time.Duration
+	ClockSamples     int64
+	// sliding windows, recomputed at most every windowsInterval
+	Windows          []WindowStats
+	WindowsUpdatedAt time.Time
+	seen             [sequenceWindow / 64]uint64 // Indices received within sequenceWindow below HighestIndex
 }
 
+// sequenceWindow is the number of indices below the highest received one remembered for duplicate detection.
+const sequenceWindow = 1024
+
+// restartThreshold is the largest drop below the highest received index taken as reordering or duplication.
+// An index further below is a restart of the vehicle, reordering that deep is unlikely at the update rates of vehicles.
+const restartThreshold = 64
+
+const windowsInterval = time.Second
+
+// maxSampleRate is the packet rate per second up to which the longest window keeps every sample.
+const maxSampleRate = 50
+
+// NetworkStatistics of a vehicle. Thread safe, the final stats are read by another goroutine than the one updating them.
 type NetworkStatistics struct {
-	Stats NetworkStats
+	sync.Mutex
+	Stats   NetworkStats
+	Samples *SlidingWindow
+	Clock   ClockEstimator
 }
 
 func NewNetworkStatistics() *NetworkStatistics {
 	return &NetworkStatistics{
-		Stats: NetworkStats{},
+		Stats:   NetworkStats{},
+		Samples: NewSlidingWindow(maxSampleRate*int(DefaultWindows[len(DefaultWindows)-1].Seconds()), DefaultWindows[len(DefaultWindows)-1]),
 	}
 }
 
 func (ns *NetworkStatistics) GetStats() NetworkStats {
+	ns.Lock()
+	defer ns.Unlock()
 	return ns.Stats
 }
 
 func (ns *NetworkStatistics) Update(datagram api.UpdateVehicleDatagram, receivedAt time.Time) {
+	ns.Lock()
+	defer ns.Unlock()
+
 	ns.Stats.PacketsReceived++
+	ns.updateSequence(datagram.Index)
+	sample := LatencySample{ReceivedAt: receivedAt}
+	defer ns.updateWindows(&sample)
 
 	// Jitter calculation
 	if !ns.Stats.PrevPacketTime.IsZero() {
@@ -48,19 +93,120 @@ func (ns *NetworkStatistics) Update(datagram api.UpdateVehicleDatagram, received
 		// Exponential moving average to smooth out jitter
 		ns.Stats.Jitter = (ns.Stats.Jitter*15 + difference) / 16
 		ns.Stats.LastDelay = currentDelay
+		sample.JitterDelta = difference
+		sample.HasJitter = true
 	}
 	ns.Stats.PrevPacketTime = receivedAt
 	// fmt.Printf("Jitter: %v\n", ns.Stats.Jitter)
 
-	sentTime, err := time.Parse(time.RFC3339, datagram.Timestamp)
+	sentTime, err := time.Parse(api.TimestampFormat, datagram.Timestamp)
 	if err != nil {
 		sentry.CaptureException(err)
 		fmt.Println("Error parsing timestamp:", err)
 		return
 	}
-	// latency calculation
-	latency := receivedAt.Sub(sentTime)
+	// latency calculation, the timestamp is converted from the vehicle clock
+	latency := receivedAt.Sub(sentTime.Add(-ns.Stats.ClockOffset))
 	ns.Stats.TotalLatency += latency
 	ns.Stats.AverageLatency = time.Duration(int64(ns.Stats.TotalLatency) / ns.Stats.PacketsReceived)
+	sample.Latency = latency
+	sample.HasLatency = true
 	// fmt.Printf("Average latency: %v\n", ns.Stats.AverageLatency)
 }
+
+// AddClockSample records a ping sent to the vehicle at sentAt, acknowledged at remoteTime
+// in the vehicle clock and received back at receivedAt.
+func (ns *NetworkStatistics) AddClockSample(sentAt time.Time, remoteTime time.Time, receivedAt time.Time) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	ns.Clock.Add(sentAt, remoteTime, receivedAt)
+	offset, errorBound, roundTrip, ok := ns.Clock.Estimate()
+	if !ok {
+		return
+	}
+	ns.Stats.ClockOffset = offset
+	ns.Stats.ClockOffsetError = errorBound
+	ns.Stats.RoundTripTime = roundTrip
+	ns.Stats.ClockSamples++
+}
+
+// updateWindows adds the sample and recomputes the windowed statistics if they are older than windowsInterval.
+func (ns *NetworkStatistics) updateWindows(sample *LatencySample) {
+	ns.Samples.Add(*sample)
+	if sample.ReceivedAt.Sub(ns.Stats.WindowsUpdatedAt) < windowsInterval {
+		return
+	}
+
+	ns.Stats.Windows = make([]WindowStats, len(DefaultWindows))
+	for i, window := range DefaultWindows {
+		ns.Stats.Windows[i] = ns.Samples.Stats(sample.ReceivedAt, window)
+	}
+	ns.Stats.WindowsUpdatedAt = sample.ReceivedAt
+}
+
+// updateSequence detects lost, reordered and duplicate datagrams by their index.
+func (ns *NetworkStatistics) updateSequence(index int) {
+	stats := &ns.Stats
+
+	if stats.PacketsExpected == 0 || index < stats.HighestIndex-restartThreshold {
+		if stats.PacketsExpected != 0 {
+			stats.SequenceResets++
+		}
+		stats.seen = [sequenceWindow / 64]uint64{}
+		stats.HighestIndex = index
+		stats.LowestIndex = index
+		stats.PacketsExpected++
+		stats.markSeen(index)
+		stats.updateLossRate()
+		return
+	}
+
+	switch {
+	case index > stats.HighestIndex:
+		gap := index - stats.HighestIndex - 1
+		stats.PacketsExpected += int64(gap + 1)
+		stats.PacketsLost += int64(gap)
+		stats.LongestGap = max(stats.LongestGap, gap)
+		if gap+1 >= sequenceWindow {
+			stats.seen = [sequenceWindow / 64]uint64{}
+		} else {
+			for skipped := stats.HighestIndex + 1; skipped < index; skipped++ {
+				stats.clearSeen(skipped)
+			}
+		}
+		stats.HighestIndex = index
+		stats.markSeen(index)
+	case stats.isSeen(index):
+		stats.Duplicates++
+	case index > stats.LowestIndex:
+		// Arrived late, it was counted as lost when a higher index arrived
+		stats.OutOfOrder++
+		stats.PacketsLost--
+		stats.markSeen(index)
+	default:
+		// Sent before the first index seen, it was never counted as expected
+		stats.OutOfOrder++
+		stats.markSeen(index)
+	}
+	stats.updateLossRate()
+}
+
+func (stats *NetworkStats) updateLossRate() {
+	stats.LossRate = float64(stats.PacketsLost) / float64(stats.PacketsExpected)
+}
+
+func (stats *NetworkStats) isSeen(index int) bool {
+	bit := uint(index) % sequenceWindow
+	return stats.seen[bit/64]&(1<<(bit%64)) != 0
+}
+
+func (stats *NetworkStats) markSeen(index int) {
+	bit := uint(index) % sequenceWindow
+	stats.seen[bit/64] |= 1 << (bit % 64)
+}
+
+func (stats *NetworkStats) clearSeen(index int) {
+	bit := uint(index) % sequenceWindow
+	stats.seen[bit/64] &^= 1 << (bit % 64)
+}
@@ -0,0 +1,88 @@
+package statistics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/TP-TEAM05/integration-api"
+)
+
+// indices returns the indices from first to last, both included.
+func indices(first int, last int) []int {
+	var result []int
+	for index := first; index <= last; index++ {
+		result = append(result, index)
+	}
+	return result
+}
+
+func TestSequenceStatistics(t *testing.T) {
+	tests := []struct {
+		name           string
+		indices        []int
+		wantExpected   int64
+		wantLost       int64
+		wantOutOfOrder int64
+		wantDuplicates int64
+		wantResets     int64
+		wantLongestGap int
+	}{
+		{"in order", indices(1, 5), 5, 0, 0, 0, 0, 0},
+		{"loss", []int{1, 2, 5, 6, 10}, 10, 5, 0, 0, 0, 3},
+		{"reordering", []int{1, 3, 2, 4, 6, 5}, 6, 0, 2, 0, 0, 1},
+		{"duplicates", []int{1, 2, 2, 3, 1}, 3, 0, 0, 2, 0, 0},
+		{"late before the first index", []int{5, 6, 4}, 2, 0, 1, 0, 0, 0},
+		{"late within the restart threshold", append(append(indices(1, 39), indices(41, 100)...), 40), 100, 0, 1, 0, 0, 1},
+		{"restart within the sequence window", append(indices(1, 100), indices(0, 9)...), 110, 0, 0, 0, 1, 0},
+		{"restart after the sequence window", append(indices(1, 2000), indices(1, 10)...), 2010, 0, 0, 0, 1, 0},
+		{"loss after restart", append(indices(1, 100), 0, 1, 4), 105, 2, 0, 0, 1, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewNetworkStatistics()
+			for _, index := range test.indices {
+				ns.updateSequence(index)
+			}
+			stats := ns.GetStats()
+			if stats.PacketsExpected != test.wantExpected || stats.PacketsLost != test.wantLost ||
+				stats.OutOfOrder != test.wantOutOfOrder || stats.Duplicates != test.wantDuplicates ||
+				stats.SequenceResets != test.wantResets || stats.LongestGap != test.wantLongestGap {
+				t.Errorf("expected %v, lost %v, out of order %v, duplicates %v, resets %v, longest gap %v, "+
+					"want %v, %v, %v, %v, %v, %v", stats.PacketsExpected, stats.PacketsLost, stats.OutOfOrder,
+					stats.Duplicates, stats.SequenceResets, stats.LongestGap, test.wantExpected, test.wantLost,
+					test.wantOutOfOrder, test.wantDuplicates, test.wantResets, test.wantLongestGap)
+			}
+			if wantRate := float64(test.wantLost) / float64(test.wantExpected); stats.LossRate != wantRate {
+				t.Errorf("loss rate %v, want %v", stats.LossRate, wantRate)
+			}
+		})
+	}
+}
+
+// TestStatsAreReadWhileUpdated is meaningful with -race, the final stats are read when the connection dies.
+func TestStatsAreReadWhileUpdated(t *testing.T) {
+	ns := NewNetworkStatistics()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for index := 1; index <= 200; index++ {
+			ns.Update(api.UpdateVehicleDatagram{
+				BaseDatagram: api.BaseDatagram{Index: index, Timestamp: time.Now().UTC().Format(api.TimestampFormat)},
+			}, time.Now())
+			ns.AddClockSample(time.Now(), time.Now(), time.Now())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			_ = ns.GetStats()
+		}
+	}()
+	wg.Wait()
+
+	if stats := ns.GetStats(); stats.PacketsReceived != 200 {
+		t.Errorf("%v packets received, want 200", stats.PacketsReceived)
+	}
+}
@@ -0,0 +1,62 @@
+package statistics
+
+import (
+	"sync"
+	"time"
+)
+
+type RoundTripStats struct {
+	RoundTripTime      time.Duration // Latest round trip
+	SmoothedRoundTrip  time.Duration // Exponential moving average like TCP SRTT
+	RoundTripVariation time.Duration // Smoothed mean deviation like TCP RTTVAR
+	MinRoundTrip       time.Duration
+	MaxRoundTrip       time.Duration
+	Samples            int64
+	LastSeen           time.Time // Time the last datagram of any type arrived
+}
+
+// RoundTripStatistics measure round trips of pings sent to a processor. Thread safe.
+type RoundTripStatistics struct {
+	sync.Mutex
+	Stats RoundTripStats
+}
+
+func NewRoundTripStatistics() *RoundTripStatistics {
+	return &RoundTripStatistics{}
+}
+
+func (rs *RoundTripStatistics) AddSample(roundTrip time.Duration) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	stats := &rs.Stats
+	if stats.Samples == 0 {
+		stats.SmoothedRoundTrip = roundTrip
+		stats.RoundTripVariation = roundTrip / 2
+		stats.MinRoundTrip = roundTrip
+		stats.MaxRoundTrip = roundTrip
+	} else {
+		deviation := stats.SmoothedRoundTrip - roundTrip
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		stats.RoundTripVariation = (stats.RoundTripVariation*3 + deviation) / 4
+		stats.SmoothedRoundTrip = (stats.SmoothedRoundTrip*7 + roundTrip) / 8
+		stats.MinRoundTrip = min(stats.MinRoundTrip, roundTrip)
+		stats.MaxRoundTrip = max(stats.MaxRoundTrip, roundTrip)
+	}
+	stats.RoundTripTime = roundTrip
+	stats.Samples++
+}
+
+func (rs *RoundTripStatistics) Seen(at time.Time) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.Stats.LastSeen = at
+}
+
+func (rs *RoundTripStatistics) GetStats() RoundTripStats {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.Stats
+}
@@ -0,0 +1,116 @@
+package statistics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StatsStore keeps the latest NetworkStats of every vehicle, shared by the connections and the subscriptions.
+type StatsStore interface {
+	// Save stores the stats of the vehicle, the write may be deferred until the next Flush.
+	Save(vin string, stats *NetworkStats) error
+	// Get returns the stats of the vehicle, nil if there are none or they expired.
+	Get(vin string) (*NetworkStats, error)
+	// GetMany returns the stats of the vehicles which have any, keyed by VIN.
+	GetMany(vins []string) (map[string]*NetworkStats, error)
+	// Flush writes the deferred stats.
+	Flush(ctx context.Context) error
+}
+
+// FlushEvery flushes the store every interval until the context is done.
+func FlushEvery(ctx context.Context, store StatsStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = store.Flush(ctx)
+		}
+	}
+}
+
+type memoryEntry struct {
+	Stats   NetworkStats
+	SavedAt time.Time
+}
+
+// MemoryStore is a StatsStore kept in the memory of the module, e.g. when Redis is not deployed.
+type MemoryStore struct {
+	sync.Mutex
+	TTL     time.Duration // Age after which the stats of a vehicle expire, 0 for no expiry
+	Entries map[string]*memoryEntry
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		TTL:     ttl,
+		Entries: make(map[string]*memoryEntry),
+	}
+}
+
+func (store *MemoryStore) Save(vin string, stats *NetworkStats) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.Entries[vin] = &memoryEntry{Stats: stats.copy(), SavedAt: time.Now()}
+	return nil
+}
+
+func (store *MemoryStore) Get(vin string) (*NetworkStats, error) {
+	store.Lock()
+	defer store.Unlock()
+	return store.get(vin), nil
+}
+
+func (store *MemoryStore) GetMany(vins []string) (map[string]*NetworkStats, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	result := make(map[string]*NetworkStats, len(vins))
+	for _, vin := range vins {
+		if stats := store.get(vin); stats != nil {
+			result[vin] = stats
+		}
+	}
+	return result, nil
+}
+
+// Flush removes the expired stats, the other writes are never deferred.
+func (store *MemoryStore) Flush(ctx context.Context) error {
+	store.Lock()
+	defer store.Unlock()
+
+	for vin, entry := range store.Entries {
+		if store.expired(entry) {
+			delete(store.Entries, vin)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) get(vin string) *NetworkStats {
+	entry, ok := store.Entries[vin]
+	if !ok {
+		return nil
+	}
+	if store.expired(entry) {
+		delete(store.Entries, vin)
+		return nil
+	}
+	stats := entry.Stats.copy()
+	return &stats
+}
+
+func (store *MemoryStore) expired(entry *memoryEntry) bool {
+	return store.TTL > 0 && time.Since(entry.SavedAt) > store.TTL
+}
+
+// copy returns the stats with their own windows, so the caller can keep updating its stats.
+func (stats *NetworkStats) copy() NetworkStats {
+	copied := *stats
+	copied.Windows = append([]WindowStats(nil), stats.Windows...)
+	return copied
+}
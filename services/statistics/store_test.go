@@ -0,0 +1,61 @@
+package statistics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreExpiresStats(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	_ = store.Save("VIN1", &NetworkStats{PacketsReceived: 1})
+	_ = store.Save("VIN2", &NetworkStats{PacketsReceived: 2})
+	store.Entries["VIN1"].SavedAt = time.Now().Add(-2 * time.Minute)
+
+	if stats, _ := store.Get("VIN1"); stats != nil {
+		t.Errorf("expired stats returned: %+v", stats)
+	}
+	stats, _ := store.GetMany([]string{"VIN1", "VIN2"})
+	if len(stats) != 1 || stats["VIN2"] == nil || stats["VIN2"].PacketsReceived != 2 {
+		t.Errorf("GetMany returned %+v, expected the stats of VIN2 only", stats)
+	}
+
+	store.Entries["VIN2"].SavedAt = time.Now().Add(-2 * time.Minute)
+	_ = store.Flush(context.Background())
+	if len(store.Entries) != 0 {
+		t.Errorf("Flush kept %v expired entries", len(store.Entries))
+	}
+}
+
+func TestMemoryStoreWithoutTTLKeepsStats(t *testing.T) {
+	store := NewMemoryStore(0)
+	_ = store.Save("VIN1", &NetworkStats{PacketsReceived: 1})
+	store.Entries["VIN1"].SavedAt = time.Now().Add(-24 * time.Hour)
+
+	_ = store.Flush(context.Background())
+	if stats, _ := store.Get("VIN1"); stats == nil {
+		t.Error("stats expired without TTL")
+	}
+}
+
+func TestMemoryStoreCopiesStats(t *testing.T) {
+	store := NewMemoryStore(0)
+	saved := &NetworkStats{PacketsReceived: 1, Windows: []WindowStats{{Packets: 10}}}
+	_ = store.Save("VIN1", saved)
+
+	// The connection keeps updating its stats after saving them
+	saved.PacketsReceived = 2
+	saved.Windows[0].Packets = 20
+	stats, _ := store.Get("VIN1")
+	if stats.PacketsReceived != 1 || stats.Windows[0].Packets != 10 {
+		t.Fatalf("stored stats changed with the saved ones: %+v", stats)
+	}
+
+	// Nor do the readers change the stored stats
+	stats.PacketsReceived = 3
+	stats.Windows[0].Packets = 30
+	many, _ := store.GetMany([]string{"VIN1"})
+	if many["VIN1"].PacketsReceived != 1 || many["VIN1"].Windows[0].Packets != 10 {
+		t.Fatalf("stored stats changed with the returned ones: %+v", many["VIN1"])
+	}
+}
@@ -0,0 +1,106 @@
+package statistics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultWindows are the durations the windowed statistics are computed for.
+var DefaultWindows = []time.Duration{10 * time.Second, 60 * time.Second, 5 * time.Minute}
+
+type LatencySample struct {
+	ReceivedAt  time.Time
+	Latency     time.Duration
+	HasLatency  bool
+	JitterDelta time.Duration // Absolute difference of the consecutive inter-arrival delays
+	HasJitter   bool
+}
+
+// WindowStats describe the packets received within the last Window.
+type WindowStats struct {
+	Window     time.Duration
+	Packets    int64
+	PacketRate float64 // Packets per second
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+	Jitter     time.Duration // Mean absolute difference of the consecutive inter-arrival delays
+}
+
+// SlidingWindow is a ring buffer of the samples received within MaxAge, at most len(Samples) of them.
+type SlidingWindow struct {
+	Samples []LatencySample
+	Start   int
+	Count   int
+	MaxAge  time.Duration
+}
+
+func NewSlidingWindow(maxSamples int, maxAge time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		Samples: make([]LatencySample, max(maxSamples, 1)),
+		MaxAge:  maxAge,
+	}
+}
+
+func (window *SlidingWindow) Add(sample LatencySample) {
+	if window.Count == len(window.Samples) {
+		window.Start = (window.Start + 1) % len(window.Samples)
+		window.Count--
+	}
+	window.Samples[(window.Start+window.Count)%len(window.Samples)] = sample
+	window.Count++
+
+	for window.Count > 0 && sample.ReceivedAt.Sub(window.at(0).ReceivedAt) > window.MaxAge {
+		window.Start = (window.Start + 1) % len(window.Samples)
+		window.Count--
+	}
+}
+
+// Stats computes the statistics of the samples received within the duration before now.
+func (window *SlidingWindow) Stats(now time.Time, duration time.Duration) WindowStats {
+	stats := WindowStats{Window: duration}
+	since := now.Add(-duration)
+
+	var latencies []time.Duration
+	var jitterSum time.Duration
+	var jitterCount int64
+	for i := window.Count - 1; i >= 0; i-- {
+		sample := window.at(i)
+		if sample.ReceivedAt.Before(since) {
+			break
+		}
+		stats.Packets++
+		if sample.HasLatency {
+			latencies = append(latencies, sample.Latency)
+		}
+		if sample.HasJitter {
+			jitterSum += sample.JitterDelta
+			jitterCount++
+		}
+	}
+
+	stats.PacketRate = float64(stats.Packets) / duration.Seconds()
+	if jitterCount > 0 {
+		stats.Jitter = jitterSum / time.Duration(jitterCount)
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		stats.LatencyP50 = percentile(latencies, 0.50)
+		stats.LatencyP95 = percentile(latencies, 0.95)
+		stats.LatencyP99 = percentile(latencies, 0.99)
+		stats.LatencyMax = latencies[len(latencies)-1]
+	}
+	return stats
+}
+
+func (window *SlidingWindow) at(i int) *LatencySample {
+	return &window.Samples[(window.Start+i)%len(window.Samples)]
+}
+
+// percentile of the sorted values using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[min(max(rank-1, 0), len(sorted)-1)]
+}